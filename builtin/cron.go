@@ -0,0 +1,82 @@
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"time"
+)
+
+const (
+	cmdCronList = "cron.list"
+
+	//cronMaxNext is the max number of next fire times listed per job
+	cronMaxNext = 100
+)
+
+func init() {
+	pm.CmdMap[cmdCronList] = process.NewInternalProcessFactory(cronList)
+}
+
+type cronListData struct {
+	Count int `json:"count"`
+}
+
+type cronJob struct {
+	ID       string  `json:"id"`
+	Command  string  `json:"command"`
+	Cron     string  `json:"cron"`
+	Timezone string  `json:"timezone,omitempty"`
+	Next     []int64 `json:"next"`
+}
+
+func cronList(cmd *core.Command) (interface{}, error) {
+	//load data
+	data := cronListData{}
+	err := json.Unmarshal(*cmd.Arguments, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Count <= 0 {
+		data.Count = 1
+	} else if data.Count > cronMaxNext {
+		return nil, fmt.Errorf("count must be at most %d", cronMaxNext)
+	}
+
+	jobs := make([]cronJob, 0)
+	now := time.Now()
+	for _, runner := range pm.GetManager().Runners() {
+		c := runner.Command()
+		if c.Cron == "" {
+			continue
+		}
+
+		schedule, err := pm.ParseCron(c.Cron, c.CronTimezone)
+		if err != nil {
+			log.Errorf("Invalid cron expression of %s: %s", c, err)
+			continue
+		}
+
+		job := cronJob{
+			ID:       c.ID,
+			Command:  c.Command,
+			Cron:     c.Cron,
+			Timezone: c.CronTimezone,
+			Next:     make([]int64, 0, data.Count),
+		}
+
+		for t := now; len(job.Next) < data.Count; {
+			if t = schedule.Next(t); t.IsZero() {
+				break
+			}
+			job.Next = append(job.Next, t.Unix())
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
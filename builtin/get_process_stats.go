@@ -51,7 +51,9 @@ func getProcessStats(cmd *core.Command) (interface{}, error) {
 			continue
 		}
 
-		stats = append(stats, process.GetStats())
+		processStats := process.GetStats()
+		processStats.Health = runner.Health()
+		stats = append(stats, processStats)
 	}
 
 	return stats, nil
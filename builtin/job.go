@@ -0,0 +1,56 @@
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+)
+
+const (
+	cmdJobList = "job.list"
+	cmdJobGet  = "job.get"
+
+	defaultJobListLimit = 100
+)
+
+func init() {
+	pm.CmdMap[cmdJobList] = process.NewInternalProcessFactory(jobList)
+	pm.CmdMap[cmdJobGet] = process.NewInternalProcessFactory(jobGet)
+}
+
+type jobGetData struct {
+	ID string `json:"id"`
+}
+
+func jobList(cmd *core.Command) (interface{}, error) {
+	//load data
+	filter := pm.HistoryFilter{}
+	err := json.Unmarshal(*cmd.Arguments, &filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultJobListLimit
+	}
+
+	return pm.GetManager().History(&filter), nil
+}
+
+func jobGet(cmd *core.Command) (interface{}, error) {
+	//load data
+	data := jobGetData{}
+	err := json.Unmarshal(*cmd.Arguments, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := pm.GetManager().HistoryResult(data.ID)
+	if !ok {
+		return nil, fmt.Errorf("Job with id '%s' doesn't exist in history", data.ID)
+	}
+
+	return result, nil
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/g8os/core.base/pm"
 	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/pm/process"
+	"syscall"
+	"time"
 )
 
 const (
@@ -16,7 +18,9 @@ func init() {
 }
 
 type killData struct {
-	ID string `json:"id"`
+	ID     string `json:"id"`
+	Signal string `json:"signal"`
+	Grace  int    `json:"grace"`
 }
 
 func kill(cmd *core.Command) (interface{}, error) {
@@ -28,6 +32,14 @@ func kill(cmd *core.Command) (interface{}, error) {
 		return nil, err
 	}
 
-	pm.GetManager().Kill(data.ID)
+	//if not set, the command own kill signal and grace period are used.
+	var sig syscall.Signal
+	if data.Signal != "" {
+		if sig, err = process.ParseSignal(data.Signal); err != nil {
+			return nil, err
+		}
+	}
+
+	pm.GetManager().Terminate(data.ID, sig, time.Duration(data.Grace)*time.Second)
 	return true, nil
 }
@@ -0,0 +1,40 @@
+package builtin
+
+import (
+	"encoding/json"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"time"
+)
+
+const (
+	cmdReload = "core.reload"
+)
+
+func init() {
+	pm.CmdMap[cmdReload] = process.NewInternalProcessFactory(reload)
+}
+
+type reloadData struct {
+	//Timeout in seconds to wait for the (re)started services to be running
+	Timeout int `json:"timeout"`
+}
+
+func reload(cmd *core.Command) (interface{}, error) {
+	data := reloadData{}
+	if err := json.Unmarshal(*cmd.Arguments, &data); err != nil {
+		return nil, err
+	}
+
+	if data.Timeout <= 0 {
+		data.Timeout = defaultServiceTimeout
+	}
+
+	report, err := pm.GetManager().Reload(time.Duration(data.Timeout) * time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,145 @@
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"time"
+)
+
+const (
+	cmdServiceStart   = "core.service.start"
+	cmdServiceStop    = "core.service.stop"
+	cmdServiceRestart = "core.service.restart"
+	cmdServiceStatus  = "core.service.status"
+	cmdServiceList    = "core.service.list"
+	cmdServiceEnable  = "core.service.enable"
+	cmdServiceDisable = "core.service.disable"
+
+	//defaultServiceTimeout is how long start and restart wait for the services to be running
+	defaultServiceTimeout = 60
+)
+
+func init() {
+	pm.CmdMap[cmdServiceStart] = process.NewInternalProcessFactory(serviceStart)
+	pm.CmdMap[cmdServiceStop] = process.NewInternalProcessFactory(serviceStop)
+	pm.CmdMap[cmdServiceRestart] = process.NewInternalProcessFactory(serviceRestart)
+	pm.CmdMap[cmdServiceStatus] = process.NewInternalProcessFactory(serviceStatus)
+	pm.CmdMap[cmdServiceList] = process.NewInternalProcessFactory(serviceList)
+	pm.CmdMap[cmdServiceEnable] = process.NewInternalProcessFactory(serviceEnable)
+	pm.CmdMap[cmdServiceDisable] = process.NewInternalProcessFactory(serviceDisable)
+}
+
+type serviceData struct {
+	Name string `json:"name"`
+	//Timeout in seconds to wait for the services to be running (start and restart only)
+	Timeout int `json:"timeout"`
+}
+
+func loadServiceData(cmd *core.Command) (*serviceData, error) {
+	data := serviceData{}
+	if err := json.Unmarshal(*cmd.Arguments, &data); err != nil {
+		return nil, err
+	}
+
+	if data.Name == "" {
+		return nil, fmt.Errorf("missing service name")
+	}
+
+	if data.Timeout <= 0 {
+		data.Timeout = defaultServiceTimeout
+	}
+
+	return &data, nil
+}
+
+//bootResult fails the command if one of the (re)started services didn't start, the report is returned either way.
+func bootResult(report *pm.BootReport, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.OK() {
+		return report, fmt.Errorf("not all services started")
+	}
+
+	return report, nil
+}
+
+func serviceStart(cmd *core.Command) (interface{}, error) {
+	data, err := loadServiceData(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return bootResult(pm.GetManager().Services().Start(data.Name, time.Duration(data.Timeout)*time.Second))
+}
+
+func serviceStop(cmd *core.Command) (interface{}, error) {
+	data, err := loadServiceData(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	stopped, err := pm.GetManager().Services().Stop(data.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return stopped, nil
+}
+
+func serviceRestart(cmd *core.Command) (interface{}, error) {
+	data, err := loadServiceData(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return bootResult(pm.GetManager().Services().Restart(data.Name, time.Duration(data.Timeout)*time.Second))
+}
+
+func serviceStatus(cmd *core.Command) (interface{}, error) {
+	data, err := loadServiceData(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := pm.GetManager().Services().Status(data.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func serviceList(cmd *core.Command) (interface{}, error) {
+	return pm.GetManager().Services().List(), nil
+}
+
+func serviceEnable(cmd *core.Command) (interface{}, error) {
+	data, err := loadServiceData(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pm.GetManager().Services().Enable(data.Name); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func serviceDisable(cmd *core.Command) (interface{}, error) {
+	data, err := loadServiceData(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pm.GetManager().Services().Disable(data.Name); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package builtin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+)
+
+const (
+	cmdWriteStdin = "core.write_stdin"
+)
+
+func init() {
+	pm.CmdMap[cmdWriteStdin] = process.NewInternalProcessFactory(writeStdin)
+}
+
+/*
+writeStdinData is the data to append to the stdin of a running job, Data is base64 encoded if Base64
+is set. If Close is set the stdin is closed (after Data is written) so the job gets an EOF.
+*/
+type writeStdinData struct {
+	ID     string `json:"id"`
+	Data   string `json:"data"`
+	Base64 bool   `json:"base64"`
+	Close  bool   `json:"close"`
+}
+
+func writeStdin(cmd *core.Command) (interface{}, error) {
+	//load data
+	data := writeStdinData{}
+	err := json.Unmarshal(*cmd.Arguments, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	input := []byte(data.Data)
+	if data.Base64 {
+		if input, err = base64.StdEncoding.DecodeString(data.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	runner, ok := pm.GetManager().Runner(data.ID)
+	if !ok {
+		return nil, fmt.Errorf("Process with id '%s' doesn't exist", data.ID)
+	}
+
+	writer, ok := runner.Process().(process.StdinWriter)
+	if !ok {
+		return nil, fmt.Errorf("Process with id '%s' doesn't accept input", data.ID)
+	}
+
+	if len(input) > 0 {
+		if err := writer.WriteStdin(input); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.Close {
+		if err := writer.CloseStdin(); err != nil {
+			return nil, err
+		}
+	}
+
+	return true, nil
+}
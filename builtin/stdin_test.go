@@ -0,0 +1,69 @@
+package builtin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func writeStdinCmd(data writeStdinData) *core.Command {
+	raw, _ := json.Marshal(data)
+	args := json.RawMessage(raw)
+	return &core.Command{
+		ID:        "write",
+		Command:   cmdWriteStdin,
+		Arguments: &args,
+	}
+}
+
+func TestWriteStdinUnknown(t *testing.T) {
+	_, err := writeStdin(writeStdinCmd(writeStdinData{ID: "no-such-job"}))
+	assert.Error(t, err)
+}
+
+func TestWriteStdin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results := make(chan *core.JobResult, 1)
+	go func() {
+		results <- pm.GetManager().PushCmdWait(ctx, &core.Command{
+			ID:      "reader",
+			Command: "core.system",
+			Arguments: core.MustArguments(map[string]interface{}{
+				"name":            "sh",
+				"args":            []string{"-c", `read line; echo "got $line"`},
+				"keep_stdin_open": true,
+			}),
+		})
+	}()
+
+	//wait for the job to run
+	for {
+		if _, ok := pm.GetManager().Runner("reader"); ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("job didn't start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	_, err := writeStdin(writeStdinCmd(writeStdinData{ID: "reader", Data: "aGVsbG8K", Base64: true, Close: true}))
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	result := <-results
+	if !assert.Equal(t, core.StateSuccess, result.State) || !assert.Len(t, result.Streams, 2) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, "got hello\n", result.Streams[0])
+}
@@ -0,0 +1,85 @@
+package builtin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+)
+
+const (
+	cmdStdin  = "core.stdin"
+	cmdResize = "core.resize"
+)
+
+func init() {
+	pm.CmdMap[cmdStdin] = process.NewInternalProcessFactory(stdin)
+	pm.CmdMap[cmdResize] = process.NewInternalProcessFactory(resize)
+}
+
+type stdinData struct {
+	ID   string `json:"id"`
+	Data string `json:"data"`
+}
+
+type resizeData struct {
+	ID   string `json:"id"`
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+//getTerminal gets the terminal of the running job with the given id
+func getTerminal(id string) (process.Terminal, error) {
+	runner, ok := pm.GetManager().Runner(id)
+	if !ok {
+		return nil, fmt.Errorf("Process with id '%s' doesn't exist", id)
+	}
+
+	terminal, ok := runner.Process().(process.Terminal)
+	if !ok {
+		return nil, fmt.Errorf("Process with id '%s' can't run in a terminal", id)
+	}
+
+	return terminal, nil
+}
+
+func stdin(cmd *core.Command) (interface{}, error) {
+	//load data
+	data := stdinData{}
+	err := json.Unmarshal(*cmd.Arguments, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	terminal, err := getTerminal(data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := terminal.Input([]byte(data.Data)); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func resize(cmd *core.Command) (interface{}, error) {
+	//load data
+	data := resizeData{}
+	err := json.Unmarshal(*cmd.Arguments, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	terminal, err := getTerminal(data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := terminal.Resize(data.Rows, data.Cols); err != nil {
+		return nil, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,301 @@
+package builtin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/utils"
+	"strings"
+	"sync"
+)
+
+const (
+	cmdWorkflow = "core.workflow"
+
+	//workflowAbort stops the workflow when the step fails, steps that didn't start yet are skipped (default)
+	workflowAbort = "abort"
+	//workflowContinue keeps running the other steps when the step fails
+	workflowContinue = "continue"
+)
+
+func init() {
+	pm.CmdMap[cmdWorkflow] = process.NewInternalContextProcessFactory(workflow)
+	//the workflow waits for its steps, which need the job slots.
+	pm.RegisterUnlimited(cmdWorkflow)
+}
+
+/*
+workflowStep is a full command with its step id, and the ids of the steps it runs after (After). A step
+whose dependencies failed is not run unless its AfterFailure is `run`.
+
+The arguments listed in Template can refer to the results of the steps that are done using `{step.data}`,
+`{step.stdout}`, `{step.stderr}`, `{step.state}` and `{step.exitcode}` placeholders in their strings. The
+other arguments are passed as is.
+*/
+type workflowStep struct {
+	core.Command
+
+	OnFailure string   `json:"on_failure"`
+	Template  []string `json:"template,omitempty"`
+}
+
+type workflowData struct {
+	Steps []*workflowStep `json:"steps"`
+}
+
+type workflowRun struct {
+	ctx context.Context
+	cmd *core.Command
+	//done channels are closed once the step result is known
+	done map[string]chan struct{}
+
+	results map[string]*core.JobResult
+	aborted bool
+	m       sync.Mutex
+}
+
+func (d *workflowData) validate() error {
+	steps := make(map[string]*workflowStep)
+	for _, step := range d.Steps {
+		if step.ID == "" {
+			return fmt.Errorf("step id is required")
+		}
+		if _, ok := steps[step.ID]; ok {
+			return fmt.Errorf("duplicate step id '%s'", step.ID)
+		}
+		switch step.OnFailure {
+		case "", workflowAbort, workflowContinue:
+		default:
+			return fmt.Errorf("invalid on_failure '%s' for step '%s'", step.OnFailure, step.ID)
+		}
+		steps[step.ID] = step
+	}
+
+	for _, step := range d.Steps {
+		for _, dep := range step.After {
+			if _, ok := steps[dep]; !ok {
+				return fmt.Errorf("step '%s' runs after unknown step '%s'", step.ID, dep)
+			}
+		}
+	}
+
+	//a cycle would make its steps wait for each other forever.
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var visit func(step *workflowStep) error
+	visit = func(step *workflowStep) error {
+		switch marks[step.ID] {
+		case visiting:
+			return fmt.Errorf("dependency cycle on step '%s'", step.ID)
+		case visited:
+			return nil
+		}
+
+		marks[step.ID] = visiting
+		for _, dep := range step.After {
+			if err := visit(steps[dep]); err != nil {
+				return err
+			}
+		}
+		marks[step.ID] = visited
+		return nil
+	}
+
+	for _, step := range d.Steps {
+		if err := visit(step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//values gets the placeholders values of the done steps
+func (w *workflowRun) values() map[string]interface{} {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	values := make(map[string]interface{})
+	for id, result := range w.results {
+		values[id+".data"] = result.Data
+		values[id+".state"] = result.State
+		if result.ExitCode != nil {
+			values[id+".exitcode"] = *result.ExitCode
+		}
+		if len(result.Streams) == 2 {
+			values[id+".stdout"] = strings.TrimSpace(result.Streams[0])
+			values[id+".stderr"] = strings.TrimSpace(result.Streams[1])
+		}
+	}
+
+	return values
+}
+
+func format(v interface{}, values map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return utils.Format(v, values)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = format(e, values)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = format(e, values)
+		}
+	}
+
+	return v
+}
+
+//command builds the job command of the step, with the outputs of the previous steps in its arguments
+func (w *workflowRun) command(step *workflowStep) (*core.Command, error) {
+	cmd := step.Command
+	cmd.ID = fmt.Sprintf("%s.%s", w.cmd.ID, step.ID)
+	cmd.After = nil
+	cmd.AfterFailure = ""
+	cmd.Route = ""
+	if cmd.Tags == "" {
+		cmd.Tags = w.cmd.Tags
+	}
+
+	if len(step.Template) == 0 {
+		return &cmd, nil
+	}
+
+	var args map[string]interface{}
+	if cmd.Arguments == nil {
+		return nil, fmt.Errorf("step '%s' has no arguments to template", step.ID)
+	} else if err := json.Unmarshal(*cmd.Arguments, &args); err != nil {
+		return nil, fmt.Errorf("step '%s' arguments can't be templated: %s", step.ID, err)
+	}
+
+	values := w.values()
+	for _, key := range step.Template {
+		if arg, ok := args[key]; ok {
+			args[key] = format(arg, values)
+		}
+	}
+
+	cmd.Arguments = core.MustArguments(args)
+	return &cmd, nil
+}
+
+func (w *workflowRun) run(step *workflowStep) *core.JobResult {
+	cmd, err := w.command(step)
+	if err != nil {
+		result := core.NewBasicJobResult(&step.Command)
+		result.State = core.StateError
+		result.Data = err.Error()
+		return result
+	}
+
+	//steps wait for a job slot like any other command, and are killed if the workflow is.
+	return pm.GetManager().PushCmdWait(w.ctx, cmd)
+}
+
+//wait waits for the given steps to be done, and tells if they all succeeded
+func (w *workflowRun) wait(steps []string) bool {
+	for _, id := range steps {
+		<-w.done[id]
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, id := range steps {
+		if w.results[id].State != core.StateSuccess {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *workflowRun) step(step *workflowStep) {
+	defer close(w.done[step.ID])
+	succeeded := w.wait(step.After)
+
+	w.m.Lock()
+	aborted := w.aborted
+	w.m.Unlock()
+
+	var result *core.JobResult
+	switch {
+	case aborted || w.ctx.Err() != nil:
+		result = core.NewBasicJobResult(&step.Command)
+		result.State = core.StateSkipped
+	case !succeeded && step.AfterFailure != core.AfterFailureRun:
+		result = core.NewBasicJobResult(&step.Command)
+		result.State = core.StateDependencyFailed
+	default:
+		result = w.run(step)
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.results[step.ID] = result
+	if result.State != core.StateSuccess && result.State != core.StateSkipped && step.OnFailure != workflowContinue {
+		w.aborted = true
+	}
+}
+
+/*
+workflow runs the steps of the workflow through the process manager, each step starts as soon as the steps
+it runs after are done. The result data holds the result of each step, the workflow fails if any of
+its steps failed. Killing the workflow kills its running steps, the other steps are skipped.
+
+The workflow itself doesn't hold a job slot, only its steps do.
+*/
+func workflow(ctx context.Context, cmd *core.Command) (interface{}, error) {
+	var data workflowData
+	if err := json.Unmarshal(*cmd.Arguments, &data); err != nil {
+		return nil, err
+	}
+
+	if err := data.validate(); err != nil {
+		return nil, err
+	}
+
+	w := &workflowRun{
+		ctx:     ctx,
+		cmd:     cmd,
+		done:    make(map[string]chan struct{}),
+		results: make(map[string]*core.JobResult),
+	}
+
+	for _, step := range data.Steps {
+		w.done[step.ID] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for _, step := range data.Steps {
+		wg.Add(1)
+		go func(step *workflowStep) {
+			defer wg.Done()
+			w.step(step)
+		}(step)
+	}
+
+	wg.Wait()
+
+	var failed []string
+	for _, step := range data.Steps {
+		if w.results[step.ID].State != core.StateSuccess {
+			failed = append(failed, step.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return w.results, fmt.Errorf("workflow steps failed: %s", strings.Join(failed, ", "))
+	}
+
+	return w.results, nil
+}
@@ -0,0 +1,178 @@
+package builtin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	recorded  []string
+	recordMux sync.Mutex
+)
+
+func init() {
+	//a single job slot, the workflows must not hold it while their steps wait for it.
+	mgr := pm.InitProcessManager(1)
+	mgr.Run()
+
+	//test.record records the order the steps run in, and returns the step arguments.
+	pm.CmdMap["test.record"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		recordMux.Lock()
+		recorded = append(recorded, cmd.ID)
+		recordMux.Unlock()
+
+		var args map[string]interface{}
+		if cmd.Arguments != nil {
+			json.Unmarshal(*cmd.Arguments, &args)
+		}
+
+		return args, nil
+	})
+
+	pm.CmdMap["test.fail"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		return nil, fmt.Errorf("step failed")
+	})
+}
+
+func workflowCmd(id string, steps string) *core.Command {
+	raw := json.RawMessage(fmt.Sprintf(`{"steps": %s}`, steps))
+	return &core.Command{
+		ID:        id,
+		Command:   cmdWorkflow,
+		Arguments: &raw,
+	}
+}
+
+func TestWorkflowValidate(t *testing.T) {
+	for _, steps := range []string{
+		`[{"id": "a", "command": "test.record", "after": ["b"]}, {"id": "b", "command": "test.record", "after": ["a"]}]`,
+		`[{"id": "a", "command": "test.record", "after": ["unknown"]}]`,
+		`[{"id": "a", "command": "test.record"}, {"id": "a", "command": "test.record"}]`,
+		`[{"command": "test.record"}]`,
+	} {
+		_, err := workflow(context.Background(), workflowCmd("invalid", steps))
+		assert.Error(t, err, steps)
+	}
+}
+
+func TestWorkflowOrder(t *testing.T) {
+	recordMux.Lock()
+	recorded = nil
+	recordMux.Unlock()
+
+	value, err := workflow(context.Background(), workflowCmd("order", `[
+		{"id": "d", "command": "test.record", "after": ["b", "c"], "template": ["value"],
+			"arguments": {"value": "{a.data}", "literal": "{a.data}"}},
+		{"id": "b", "command": "test.record", "after": ["a"]},
+		{"id": "c", "command": "test.record", "after": ["a"]},
+		{"id": "a", "command": "test.record", "arguments": {"value": "first"}}
+	]`))
+
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	recordMux.Lock()
+	order := make(map[string]int)
+	for i, id := range recorded {
+		order[id] = i
+	}
+	recordMux.Unlock()
+
+	if !assert.Len(t, order, 4) || !assert.Equal(t, 0, order["order.a"]) || !assert.Equal(t, 3, order["order.d"]) {
+		t.Fail()
+	}
+
+	var args map[string]string
+	results := value.(map[string]*core.JobResult)
+	if !assert.NoError(t, json.Unmarshal([]byte(results["d"].Data), &args)) {
+		t.Fatal()
+	}
+
+	//only the templated arguments are formatted
+	if !assert.Equal(t, `{"value":"first"}`, args["value"]) || !assert.Equal(t, "{a.data}", args["literal"]) {
+		t.Fail()
+	}
+}
+
+func TestWorkflowFailure(t *testing.T) {
+	cmd := workflowCmd("failure", `[
+		{"id": "fail", "command": "test.fail", "on_failure": "continue"},
+		{"id": "after", "command": "test.record", "after": ["fail"], "on_failure": "continue"},
+		{"id": "anyway", "command": "test.record", "after": ["fail"], "after_failure": "run"}
+	]`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := pm.GetManager().PushCmdWait(ctx, cmd)
+	if !assert.Equal(t, core.StateError, result.State) || !assert.Contains(t, result.Critical, "workflow steps failed") {
+		t.Fatal()
+	}
+
+	var steps map[string]*core.JobResult
+	if !assert.NoError(t, json.Unmarshal([]byte(result.Data), &steps)) {
+		t.Fatal()
+	}
+
+	if !assert.Equal(t, core.StateError, steps["fail"].State) ||
+		!assert.Equal(t, core.StateDependencyFailed, steps["after"].State) {
+		t.Fail()
+	}
+
+	if !assert.Equal(t, core.StateSuccess, steps["anyway"].State) {
+		t.Fail()
+	}
+}
+
+func TestWorkflowKill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	begin := time.Now()
+	value, err := workflow(ctx, workflowCmd("killed", `[
+		{"id": "sleep", "command": "core.system", "arguments": {"name": "sleep", "args": ["30"]}},
+		{"id": "next", "command": "test.record", "after": ["sleep"]}
+	]`))
+
+	if !assert.Error(t, err) || !assert.True(t, time.Since(begin) < 10*time.Second) {
+		t.Fatal()
+	}
+
+	results := value.(map[string]*core.JobResult)
+	if !assert.Equal(t, core.StateKilled, results["sleep"].State) || !assert.True(t, strings.HasPrefix(err.Error(), "workflow steps failed")) {
+		t.Fail()
+	}
+
+	if !assert.Equal(t, core.StateSkipped, results["next"].State) {
+		t.Fail()
+	}
+}
+
+func TestWorkflowSlots(t *testing.T) {
+	if !assert.False(t, settings.Settings.Main.BuiltinBypass) {
+		t.Fatal()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := pm.GetManager().PushCmdWait(ctx, workflowCmd("slots", `[
+		{"id": "a", "command": "test.record"},
+		{"id": "b", "command": "test.record", "after": ["a"]}
+	]`))
+
+	if !assert.Equal(t, core.StateSuccess, result.State, "the workflow waited for the job slot it holds") {
+		t.Fail()
+	}
+}
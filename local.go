@@ -79,7 +79,8 @@ func (l *Local) server(con net.Conn) {
 		return
 	}
 
-	go runner.Run()
+	//RunCmd already started the runner, running it a second time would run the job twice and close its done
+	//channel twice.
 	lresult.State = core.StateSuccess
 
 	if lcmd.Sync {
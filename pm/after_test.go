@@ -0,0 +1,99 @@
+package pm
+
+import (
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func afterTestManager(t *testing.T) (*PM, func(ids ...string) map[string]*core.JobResult) {
+	cmdMapMux.Lock()
+	CmdMap["test.ok"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		return nil, nil
+	})
+	CmdMap["test.fail"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		return nil, fmt.Errorf("failed")
+	})
+	cmdMapMux.Unlock()
+
+	mgr := InitProcessManager(10)
+	go mgr.processCmds()
+
+	results := make(chan *core.JobResult, 10)
+	mgr.AddResultHandler(func(cmd *core.Command, result *core.JobResult) {
+		results <- result
+	})
+
+	wait := func(ids ...string) map[string]*core.JobResult {
+		got := make(map[string]*core.JobResult)
+		timeout := time.After(5 * time.Second)
+		for len(got) < len(ids) {
+			select {
+			case result := <-results:
+				got[result.ID] = result
+			case <-timeout:
+				t.Fatalf("missing results, got %v", got)
+			}
+		}
+
+		return got
+	}
+
+	return mgr, wait
+}
+
+func TestAfter_Success(t *testing.T) {
+	mgr, wait := afterTestManager(t)
+
+	mgr.PushCmd(&core.Command{ID: "second", Command: "test.ok", After: []string{"first"}})
+	mgr.PushCmd(&core.Command{ID: "first", Command: "test.ok"})
+
+	results := wait("first", "second")
+	if !assert.Equal(t, core.StateSuccess, results["second"].State) {
+		t.Fail()
+	}
+
+	//the state of an expired job is found in the history.
+	mgr.jobStates.Expire(time.Now())
+	mgr.PushCmd(&core.Command{ID: "third", Command: "test.ok", After: []string{"first"}})
+	if !assert.Equal(t, core.StateSuccess, wait("third")["third"].State) {
+		t.Fail()
+	}
+}
+
+func TestAfter_Failure(t *testing.T) {
+	mgr, wait := afterTestManager(t)
+
+	mgr.PushCmd(&core.Command{ID: "skipped", Command: "test.ok", After: []string{"failing"}})
+	mgr.PushCmd(&core.Command{ID: "run", Command: "test.ok", After: []string{"failing"}, AfterFailure: core.AfterFailureRun})
+	mgr.PushCmd(&core.Command{ID: "failing", Command: "test.fail"})
+
+	results := wait("failing", "skipped", "run")
+	if !assert.Equal(t, core.StateError, results["failing"].State) ||
+		!assert.Equal(t, core.StateDependencyFailed, results["skipped"].State) ||
+		!assert.Equal(t, core.StateSuccess, results["run"].State) {
+		t.Fail()
+	}
+}
+
+func TestAfter_Timeout(t *testing.T) {
+	mgr, wait := afterTestManager(t)
+
+	mgr.PushCmd(&core.Command{ID: "waiting", Command: "test.ok", After: []string{"never"}, AfterTimeout: 1})
+
+	result := wait("waiting")["waiting"]
+	if !assert.Equal(t, core.StateDependencyFailed, result.State) || !assert.Contains(t, result.Data, "never") {
+		t.Fail()
+	}
+
+	//the shutdown ends the waits right away.
+	mgr.PushCmd(&core.Command{ID: "cancelled", Command: "test.ok", After: []string{"never"}})
+	mgr.Shutdown(nil, time.Second)
+
+	if !assert.Equal(t, core.StateCancelled, wait("cancelled")["cancelled"].State) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,79 @@
+package pm
+
+import (
+	"context"
+	"github.com/g8os/core.base/pm/core"
+)
+
+//awaitedCmd is a command pushed with PushCmdWait
+type awaitedCmd struct {
+	result    chan *core.JobResult
+	cancelled bool
+}
+
+/*
+PushCmdWait pushes the command like PushCmd (or PushCmdToQueue if it has a queue), and waits for its result.
+
+If the context is done first, the command is cancelled: it's killed if it's running, or it gets a CANCELLED
+result when its turn comes. PushCmdWait still waits for the result of the cancelled command.
+*/
+func (pm *PM) PushCmdWait(ctx context.Context, cmd *core.Command) *core.JobResult {
+	awaited := &awaitedCmd{
+		result: make(chan *core.JobResult, 1),
+	}
+
+	pm.runnersMux.Lock()
+	pm.awaited[cmd] = awaited
+	pm.runnersMux.Unlock()
+
+	if cmd.Queue == "" {
+		pm.PushCmd(cmd)
+	} else {
+		pm.PushCmdToQueue(cmd)
+	}
+
+	select {
+	case result := <-awaited.result:
+		return result
+	case <-ctx.Done():
+	}
+
+	pm.cancelAwaited(cmd)
+	return <-awaited.result
+}
+
+//cancelAwaited kills the awaited command if it's running, or marks it so it's not started.
+func (pm *PM) cancelAwaited(cmd *core.Command) {
+	pm.runnersMux.Lock()
+	defer pm.runnersMux.Unlock()
+
+	awaited, ok := pm.awaited[cmd]
+	if !ok {
+		//the result is already there
+		return
+	}
+
+	awaited.cancelled = true
+	if runner, ok := pm.runners[cmd.ID]; ok && runner.Command() == cmd {
+		log.Infof("Killing %s, it was cancelled", cmd)
+		go runner.Kill()
+	}
+}
+
+//cancelled tells if the command was cancelled before it started, must be called with the runners lock held.
+func (pm *PM) cancelled(cmd *core.Command) bool {
+	awaited, ok := pm.awaited[cmd]
+	return ok && awaited.cancelled
+}
+
+//deliver sends the result to the caller waiting for it, if any
+func (pm *PM) deliver(cmd *core.Command, result *core.JobResult) {
+	pm.runnersMux.Lock()
+	awaited, ok := pm.awaited[cmd]
+	delete(pm.awaited, cmd)
+	pm.runnersMux.Unlock()
+
+	if ok {
+		awaited.result <- result
+	}
+}
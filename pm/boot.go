@@ -0,0 +1,68 @@
+package pm
+
+import (
+	"time"
+)
+
+const (
+	//BootStarted the service is running (or exited successfully)
+	BootStarted = "started"
+	//BootFailed the service exited with an error, or failed to get ready
+	BootFailed = "failed"
+	//BootSkipped the service was not started because one of its dependencies failed or timed out
+	BootSkipped = "skipped"
+	//BootDisabled the service was not started because it's disabled
+	BootDisabled = "disabled"
+	//BootTimeout the service was started but was not running yet when the boot timed out
+	BootTimeout = "timeout"
+)
+
+//ServiceReport is the boot outcome of a single startup service
+type ServiceReport struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	//BlockedBy the dependencies that failed or timed out, for a skipped service
+	BlockedBy []string `json:"blocked_by,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+//BootReport is the outcome of running a startup slice
+type BootReport struct {
+	Services []*ServiceReport `json:"services"`
+	//Duration of the boot in seconds
+	Duration float64 `json:"duration"`
+	TimedOut bool    `json:"timed_out"`
+}
+
+//OK tells if all the services of the slice started
+func (r *BootReport) OK() bool {
+	for _, service := range r.Services {
+		if service.State != BootStarted {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Count gets the number of services in the given state
+func (r *BootReport) Count(state string) int {
+	count := 0
+	for _, service := range r.Services {
+		if service.State == state {
+			count++
+		}
+	}
+
+	return count
+}
+
+func newBootReport() *BootReport {
+	return &BootReport{
+		Services: make([]*ServiceReport, 0),
+	}
+}
+
+func (r *BootReport) done(start time.Time) {
+	r.Duration = time.Since(start).Seconds()
+}
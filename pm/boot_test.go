@@ -0,0 +1,71 @@
+package pm
+
+import (
+	"context"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBootReport(t *testing.T) {
+	report := newBootReport()
+	report.Services = append(report.Services,
+		&ServiceReport{ID: "net", State: BootStarted},
+		&ServiceReport{ID: "db", State: BootFailed},
+		&ServiceReport{ID: "app", State: BootSkipped, BlockedBy: []string{"db"}},
+	)
+
+	if !assert.False(t, report.OK()) || !assert.Equal(t, 1, report.Count(BootSkipped)) {
+		t.Fail()
+	}
+
+	report.Services = report.Services[:1]
+	if !assert.True(t, report.OK()) {
+		t.Fail()
+	}
+}
+
+func TestBootTimeout(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.service"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.service")
+
+	mgr := InitProcessManager(10)
+
+	//db runs but never signals that it is running (a negative running delay has no hook), app waits for it.
+	slice := settings.StartupSlice{
+		settings.Startup{Name: "test.service", RunningDelay: -1}.WithKey("db"),
+		settings.Startup{Name: "test.service", After: []string{"db"}}.WithKey("app"),
+	}
+	defer mgr.stopServices(context.Background(), slice)
+
+	report := mgr.RunSliceReport(slice, 200*time.Millisecond)
+	if !assert.True(t, report.TimedOut) || !assert.Len(t, report.Services, 2) {
+		t.Fatal()
+	}
+
+	services := make(map[string]*ServiceReport)
+	for _, service := range report.Services {
+		services[service.ID] = service
+	}
+
+	if !assert.Equal(t, BootTimeout, services["db"].State) {
+		t.Fail()
+	}
+
+	app := services["app"]
+	if !assert.Equal(t, BootSkipped, app.State) || !assert.Equal(t, []string{"db"}, app.BlockedBy) || !assert.NotEmpty(t, app.Error) {
+		t.Fail()
+	}
+
+	if _, ok := mgr.Runner("app"); !assert.False(t, ok) {
+		t.Fail()
+	}
+}
@@ -5,6 +5,7 @@ package pm
 import (
 	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/pm/process"
+	"sync"
 )
 
 /*
@@ -14,10 +15,19 @@ var CmdMap = map[string]process.ProcessFactory{
 	process.CommandSystem: process.NewSystemProcess,
 }
 
+//cmdMapMux guards the CmdMap against extensions being (un)registered at runtime
+var cmdMapMux sync.RWMutex
+
+//unlimitedCmds are the commands that don't take a job slot, guarded by the cmdMapMux
+var unlimitedCmds = map[string]bool{}
+
 /*
 NewProcess creates a new process from a command
 */
 func GetProcessFactory(cmd *core.Command) process.ProcessFactory {
+	cmdMapMux.RLock()
+	defer cmdMapMux.RUnlock()
+
 	return CmdMap[cmd.Command]
 }
 
@@ -25,12 +35,36 @@ func GetProcessFactory(cmd *core.Command) process.ProcessFactory {
 RegisterCmd registers a new command (extension) so it can be executed via commands
 */
 func RegisterCmd(cmd string, exe string, workdir string, cmdargs []string, env map[string]string) {
+	cmdMapMux.Lock()
+	defer cmdMapMux.Unlock()
+
 	CmdMap[cmd] = process.NewExtensionProcessFactory(exe, workdir, cmdargs, env)
 }
 
+/*
+RegisterUnlimited marks the command as not limited by the max jobs. A command that pushes other jobs and waits
+for them (like core.workflow) must not hold a job slot, or it would wait forever for the slots it holds.
+*/
+func RegisterUnlimited(cmd string) {
+	cmdMapMux.Lock()
+	defer cmdMapMux.Unlock()
+
+	unlimitedCmds[cmd] = true
+}
+
+func isUnlimited(cmd *core.Command) bool {
+	cmdMapMux.RLock()
+	defer cmdMapMux.RUnlock()
+
+	return unlimitedCmds[cmd.Command]
+}
+
 /*
 UnregisterCmd removes an extension from the global registery
 */
 func UnregisterCmd(cmd string) {
+	cmdMapMux.Lock()
+	defer cmdMapMux.Unlock()
+
 	delete(CmdMap, cmd)
 }
@@ -0,0 +1,91 @@
+package pm
+
+import (
+	"container/list"
+	"github.com/g8os/core.base/pm/core"
+	"sync"
+	"time"
+)
+
+type completedJob struct {
+	result *core.JobResult
+	at     time.Time
+}
+
+/*
+completedJobs remembers the results of the recently completed jobs for a time window, so a command that is
+received again with the same ID (a controller retry) gets the stored result instead of running again. A zero
+window disables it.
+*/
+type completedJobs struct {
+	window time.Duration
+	jobs   map[string]*list.Element
+	order  *list.List
+
+	m sync.Mutex
+}
+
+func newCompletedJobs(window time.Duration) *completedJobs {
+	return &completedJobs{
+		window: window,
+		jobs:   make(map[string]*list.Element),
+		order:  list.New(),
+	}
+}
+
+//expire drops the jobs that completed before the window
+func (c *completedJobs) expire(now time.Time) {
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		job := e.Value.(*completedJob)
+		if now.Sub(job.at) < c.window {
+			break
+		}
+
+		c.order.Remove(e)
+		if c.jobs[job.result.ID] == e {
+			delete(c.jobs, job.result.ID)
+		}
+	}
+}
+
+//Add remembers a job result
+func (c *completedJobs) Add(result *core.JobResult) {
+	if c.window <= 0 || result.ID == "" || result.State == core.StateDuplicateID {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+	c.expire(now)
+
+	if e, ok := c.jobs[result.ID]; ok {
+		c.order.Remove(e)
+	}
+
+	c.jobs[result.ID] = c.order.PushBack(&completedJob{
+		result: result,
+		at:     now,
+	})
+}
+
+//Get gets a copy of the result of a job that completed within the window
+func (c *completedJobs) Get(id string) (*core.JobResult, bool) {
+	if c.window <= 0 || id == "" {
+		return nil, false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.expire(time.Now())
+
+	e, ok := c.jobs[id]
+	if !ok {
+		return nil, false
+	}
+
+	result := *e.Value.(*completedJob).result
+	return &result, true
+}
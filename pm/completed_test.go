@@ -0,0 +1,48 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompleted_Window(t *testing.T) {
+	completed := newCompletedJobs(200 * time.Millisecond)
+
+	completed.Add(&core.JobResult{ID: "job", State: core.StateSuccess, Data: "done"})
+
+	result, ok := completed.Get("job")
+	if !assert.True(t, ok) || !assert.Equal(t, "done", result.Data) {
+		t.Fatal()
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	_, ok = completed.Get("job")
+	if !assert.False(t, ok) {
+		t.Fail()
+	}
+}
+
+func TestCompleted_Disabled(t *testing.T) {
+	completed := newCompletedJobs(0)
+
+	completed.Add(&core.JobResult{ID: "job", State: core.StateSuccess})
+
+	_, ok := completed.Get("job")
+	if !assert.False(t, ok) {
+		t.Fail()
+	}
+}
+
+func TestCompleted_IgnoreDuplicates(t *testing.T) {
+	completed := newCompletedJobs(time.Minute)
+
+	completed.Add(&core.JobResult{ID: "job", State: core.StateDuplicateID})
+
+	_, ok := completed.Get("job")
+	if !assert.False(t, ok) {
+		t.Fail()
+	}
+}
@@ -7,17 +7,90 @@ import (
 
 type Route string
 
+const (
+	//AfterFailureSkip doesn't run the command if one of its dependencies failed (default)
+	AfterFailureSkip = "skip"
+	//AfterFailureRun runs the command once its dependencies are done, even if they failed
+	AfterFailureRun = "run"
+
+	//CronOverlapSkip skips the fire times that were missed while the job was running (default)
+	CronOverlapSkip = "skip"
+	//CronOverlapQueue runs the job once right after it finishes if a fire time was missed while it was running
+	CronOverlapQueue = "queue"
+)
+
+/*
+RestartPolicy controls how a failing command is restarted. The delay between restarts starts at Delay and
+is multiplied by Multiplier after each restart up to MaxDelay, then spread randomly by up to a fraction Jitter
+(in [0, 1]) of it. A run that lasts for ResetAfter seconds is considered healthy and resets the delay and the
+restarts count.
+
+A command that restarts more than CrashLoopCount times within CrashLoopWindow seconds is stopped with a
+CRASHLOOP state, a negative CrashLoopCount disables the crash loop detection.
+
+By default all failed runs are restarted. If OnExitCodes or OnOutput are set, a failed run is only restarted
+if it exited with one of the exit codes, or if one of its stdout/stderr lines matched one of the OnOutput
+regular expressions.
+*/
+type RestartPolicy struct {
+	Delay           int     `json:"delay,omitempty"`
+	Multiplier      float64 `json:"multiplier,omitempty"`
+	MaxDelay        int     `json:"max_delay,omitempty"`
+	Jitter          float64 `json:"jitter,omitempty"`
+	Unlimited       bool    `json:"unlimited,omitempty"`
+	ResetAfter      int     `json:"reset_after,omitempty"`
+	CrashLoopCount  int     `json:"crashloop_count,omitempty"`
+	CrashLoopWindow int     `json:"crashloop_window,omitempty"`
+
+	OnExitCodes []int    `json:"on_exit_codes,omitempty"`
+	OnOutput    []string `json:"on_output,omitempty"`
+}
+
+/*
+HealthCheck is a liveness probe of a running job, exactly one of Exec, TCP, HTTP or Match must be set. The
+probe runs every Interval seconds once the job has been running for Delay seconds. A job whose probe fails
+Threshold times in a row is killed and restarted, it's stopped with a CRASHLOOP state if it keeps failing
+its health check (with the default crash loop limits if the command has no restart policy).
+*/
+type HealthCheck struct {
+	//Exec is a command (and its arguments) that must exit with 0
+	Exec []string `json:"exec,omitempty"`
+	//TCP is an address (host:port) that must accept connections
+	TCP string `json:"tcp,omitempty"`
+	//HTTP is a url that must answer a GET with a status below 400
+	HTTP string `json:"http,omitempty"`
+	//Match is a regular expression that an output line of the job must match within each interval
+	Match string `json:"match,omitempty"`
+
+	Interval  int `json:"interval,omitempty"`
+	Timeout   int `json:"timeout,omitempty"`
+	Delay     int `json:"delay,omitempty"`
+	Threshold int `json:"threshold,omitempty"`
+}
+
 //Cmd is an executable command
 type Command struct {
 	ID              string           `json:"id"`
 	Command         string           `json:"command"`
 	Arguments       *json.RawMessage `json:"arguments"`
 	Queue           string           `json:"queue"`
+	QueueSlots      int              `json:"queue_slots,omitempty"`
+	Priority        int              `json:"priority,omitempty"`
 	StatsInterval   int              `json:"stats_interval,omitempty"`
 	MaxTime         int              `json:"max_time,omitempty"`
 	MaxRestart      int              `json:"max_restart,omitempty"`
+	Restart         *RestartPolicy   `json:"restart,omitempty"`
+	Health          *HealthCheck     `json:"health,omitempty"`
 	RecurringPeriod int              `json:"recurring_period,omitempty"`
+	Cron            string           `json:"cron,omitempty"`
+	CronTimezone    string           `json:"cron_timezone,omitempty"`
+	CronOverlap     string           `json:"cron_overlap,omitempty"`
+	KillSignal      string           `json:"kill_signal,omitempty"`
+	KillGrace       int              `json:"kill_grace,omitempty"`
 	LogLevels       []int            `json:"log_levels,omitempty"`
+	After           []string         `json:"after,omitempty"`
+	AfterFailure    string           `json:"after_failure,omitempty"`
+	AfterTimeout    int              `json:"after_timeout,omitempty"`
 	Tags            string           `json:"tags"`
 
 	Route Route `json:"-"`
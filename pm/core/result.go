@@ -13,10 +13,62 @@ const (
 	StateUnknownCmd = "UNKNOWN_CMD"
 	//StateDuplicateID dublicate id exit status
 	StateDuplicateID = "DUPILICATE_ID"
+	//StateLost job was lost during agent restart, or it was adopted after the restart and its exit status is unknown
+	StateLost = "LOST"
+	//StateCrashLoop job was restarting too fast too often
+	StateCrashLoop = "CRASHLOOP"
+	//StateDependencyFailed job didn't run because one of its dependencies failed
+	StateDependencyFailed = "DEPENDENCY_FAILED"
+	//StateSkipped job didn't run because the workflow it belongs to was aborted
+	StateSkipped = "SKIPPED"
+	//StateOOMKilled job was killed for going above its memory limit
+	StateOOMKilled = "OOM_KILLED"
+	//StateUnhealthy job was killed because its health check failed
+	StateUnhealthy = "UNHEALTHY"
+	//StateCancelled job didn't run because the agent is shutting down, or it was cancelled by the workflow it belongs to
+	StateCancelled = "CANCELLED"
+	//StateRejected job didn't run because a middleware rejected it
+	StateRejected = "REJECTED"
+
+	//HealthStarting no health check was done yet
+	HealthStarting = "starting"
+	//HealthHealthy last health check succeeded
+	HealthHealthy = "healthy"
+	//HealthUnhealthy last health check failed
+	HealthUnhealthy = "unhealthy"
 )
 
+//HealthStatus is the health of a running job
+type HealthStatus struct {
+	Status string `json:"status"`
+	//Failures is the number of consecutive failed checks
+	Failures int `json:"failures"`
+	//Error of the last failed check
+	Error string `json:"error,omitempty"`
+	//LastCheck time of the last check (epoch in milliseconds)
+	LastCheck int64 `json:"last_check,omitempty"`
+}
+
+/*
+ExitStatus represents how a process exited and its resource usage. ExitCode is only set if the process exited on
+its own, a process terminated by a signal (or a job that never had a process) has no exit code.
+*/
+type ExitStatus struct {
+	ExitCode *int   `json:"exitcode,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+	CoreDump bool   `json:"coredump,omitempty"`
+	//UserTime user cpu time in milliseconds
+	UserTime int64 `json:"usertime,omitempty"`
+	//SysTime system cpu time in milliseconds
+	SysTime int64 `json:"systime,omitempty"`
+	//MaxRSS max resident set size in kilobytes
+	MaxRSS int64 `json:"maxrss,omitempty"`
+}
+
 //JobResult represents a result of a job
 type JobResult struct {
+	ExitStatus
+
 	ID        string   `json:"id"`
 	Command   string   `json:"command"`
 	Data      string   `json:"data"`
@@ -0,0 +1,246 @@
+package pm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//CronSchedule computes the fire times of a cron expression
+type CronSchedule interface {
+	//Next gets the first fire time strictly after t, zero time if the expression never fires.
+	Next(t time.Time) time.Time
+}
+
+type cronField struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	cronSecond = cronField{0, 59, nil}
+	cronMinute = cronField{0, 59, nil}
+	cronHour   = cronField{0, 23, nil}
+	cronDom    = cronField{1, 31, nil}
+	cronMonth  = cronField{1, 12, map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	//both 0 and 7 are sunday
+	cronDow = cronField{0, 7, map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+type cronScheduleImpl struct {
+	second, minute, hour, dom, month, dow uint64
+
+	domStar, dowStar bool
+	location         *time.Location
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+//parse parses a cron field of the format `*`, `*/step`, `a`, `a-b`, `a-b/step` or a comma separated list of those.
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:i]
+		}
+
+		var lower, upper int
+		if part == "*" || part == "?" {
+			lower, upper = f.min, f.max
+		} else if i := strings.Index(part, "-"); i >= 0 {
+			var err error
+			if lower, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if upper, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+			if upper < lower {
+				return 0, fmt.Errorf("invalid range '%s'", part)
+			}
+		} else {
+			var err error
+			if lower, err = f.value(part); err != nil {
+				return 0, err
+			}
+			upper = lower
+			if step > 1 {
+				//a/step means from a to the end of the range
+				upper = f.max
+			}
+		}
+
+		for v := lower; v <= upper; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+/*
+ParseCron parses a standard cron expression with 5 fields (minute hour day-of-month month day-of-week) or
+6 fields (with seconds first), or one of the @yearly, @monthly, @weekly, @daily and @hourly macros. Fire times
+are computed in the given timezone (local time if empty).
+*/
+func ParseCron(expr string, timezone string) (CronSchedule, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s': %s", timezone, err)
+		}
+	}
+
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression '%s': expecting 5 or 6 fields", expr)
+	}
+
+	schedule := &cronScheduleImpl{
+		location: location,
+		domStar:  fields[3] == "*" || fields[3] == "?",
+		dowStar:  fields[5] == "*" || fields[5] == "?",
+	}
+
+	targets := []*uint64{&schedule.second, &schedule.minute, &schedule.hour, &schedule.dom, &schedule.month, &schedule.dow}
+	for i, f := range []cronField{cronSecond, cronMinute, cronHour, cronDom, cronMonth, cronDow} {
+		bits, err := f.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %s", expr, err)
+		}
+		*targets[i] = bits
+	}
+
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+
+	return schedule, nil
+}
+
+func (s *cronScheduleImpl) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	//if both day fields are restricted, the day matches if any of them does
+	if !s.domStar && !s.dowStar {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+func (s *cronScheduleImpl) Next(t time.Time) time.Time {
+	loc := s.location
+	t = t.In(loc).Truncate(time.Second).Add(time.Second)
+
+	//no match within 5 years means the expression never fires (ex: 30th of February)
+	limit := t.Year() + 5
+
+	//when a field doesn't match, all the lower fields are reset to their start.
+	reset := false
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !reset {
+			reset = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !reset {
+			reset = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !reset {
+			reset = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !reset {
+			reset = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
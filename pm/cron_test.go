@@ -0,0 +1,102 @@
+package pm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func mustNext(t *testing.T, expr string, from time.Time) time.Time {
+	schedule, err := ParseCron(expr, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schedule.Next(from)
+}
+
+func TestCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "* * * FOO *"} {
+		_, err := ParseCron(expr, "")
+		if !assert.Error(t, err, expr) {
+			t.Fail()
+		}
+	}
+
+	_, err := ParseCron("* * * * *", "Not/AZone")
+	if !assert.Error(t, err) {
+		t.Fail()
+	}
+}
+
+func TestCron_Daily(t *testing.T) {
+	from := time.Date(2016, 5, 10, 13, 30, 0, 0, time.UTC)
+	next := mustNext(t, "0 2 * * *", from)
+
+	if !assert.Equal(t, time.Date(2016, 5, 11, 2, 0, 0, 0, time.UTC), next) {
+		t.Fail()
+	}
+}
+
+func TestCron_Seconds(t *testing.T) {
+	from := time.Date(2016, 5, 10, 13, 30, 0, 0, time.UTC)
+	next := mustNext(t, "*/15 * * * * *", from)
+
+	if !assert.Equal(t, time.Date(2016, 5, 10, 13, 30, 15, 0, time.UTC), next) {
+		t.Fail()
+	}
+}
+
+func TestCron_Names(t *testing.T) {
+	//2016-05-10 is a tuesday
+	from := time.Date(2016, 5, 10, 13, 30, 0, 0, time.UTC)
+	next := mustNext(t, "30 8 * JUN-AUG mon", from)
+
+	if !assert.Equal(t, time.Date(2016, 6, 6, 8, 30, 0, 0, time.UTC), next) {
+		t.Fail()
+	}
+}
+
+func TestCron_DomOrDow(t *testing.T) {
+	//both day of month and day of week are restricted, any of them matches.
+	from := time.Date(2016, 5, 10, 13, 30, 0, 0, time.UTC)
+	next := mustNext(t, "0 0 20 * 5", from)
+
+	//first friday after the 10th
+	if !assert.Equal(t, time.Date(2016, 5, 13, 0, 0, 0, 0, time.UTC), next) {
+		t.Fail()
+	}
+}
+
+func TestCron_Macro(t *testing.T) {
+	from := time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)
+	next := mustNext(t, "@yearly", from)
+
+	if !assert.Equal(t, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), next) {
+		t.Fail()
+	}
+}
+
+func TestCron_Timezone(t *testing.T) {
+	schedule, err := ParseCron("0 2 * * *", "Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2016, 1, 10, 12, 0, 0, 0, time.UTC)
+	next := schedule.Next(from)
+
+	//paris is UTC+1 in winter
+	if !assert.Equal(t, time.Date(2016, 1, 11, 1, 0, 0, 0, time.UTC).Unix(), next.Unix()) {
+		t.Fail()
+	}
+}
+
+func TestCron_Never(t *testing.T) {
+	from := time.Date(2016, 1, 10, 12, 0, 0, 0, time.UTC)
+	next := mustNext(t, "0 0 30 2 *", from)
+
+	if !assert.True(t, next.IsZero()) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,160 @@
+package pm
+
+import (
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthInterval  = 10 * time.Second
+	defaultHealthTimeout   = 5 * time.Second
+	defaultHealthThreshold = 3
+)
+
+/*
+healthChecker runs the liveness probe of a job and keeps its health status. The probes are run by the
+runner loop, one at a time, and the status is reset on each (re)start of the job.
+*/
+type healthChecker struct {
+	check *core.HealthCheck
+	table process.PIDTable
+
+	interval  time.Duration
+	timeout   time.Duration
+	delay     time.Duration
+	threshold int
+
+	pattern *regexp.Regexp
+	matched bool
+
+	status core.HealthStatus
+	m      sync.Mutex
+}
+
+func newHealthChecker(check *core.HealthCheck, table process.PIDTable) (*healthChecker, error) {
+	if check == nil {
+		return nil, nil
+	}
+
+	probes := 0
+	for _, set := range []bool{len(check.Exec) > 0, check.TCP != "", check.HTTP != "", check.Match != ""} {
+		if set {
+			probes++
+		}
+	}
+
+	if probes != 1 {
+		return nil, fmt.Errorf("health check must have exactly one of exec, tcp, http or match")
+	}
+
+	h := &healthChecker{
+		check:     check,
+		table:     table,
+		interval:  defaultHealthInterval,
+		timeout:   defaultHealthTimeout,
+		delay:     time.Duration(check.Delay) * time.Second,
+		threshold: defaultHealthThreshold,
+	}
+
+	if check.Interval > 0 {
+		h.interval = time.Duration(check.Interval) * time.Second
+	}
+	if check.Timeout > 0 {
+		h.timeout = time.Duration(check.Timeout) * time.Second
+	}
+	if check.Threshold > 0 {
+		h.threshold = check.Threshold
+	}
+
+	if check.Match != "" {
+		var err error
+		if h.pattern, err = regexp.Compile(check.Match); err != nil {
+			return nil, fmt.Errorf("invalid health check pattern '%s': %s", check.Match, err)
+		}
+	}
+
+	return h, nil
+}
+
+//begin must be called before each run of the job
+func (h *healthChecker) begin() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.matched = false
+	h.status = core.HealthStatus{Status: core.HealthStarting}
+}
+
+//Status gets the current health status
+func (h *healthChecker) Status() *core.HealthStatus {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	status := h.status
+	return &status
+}
+
+//observe checks an output line of the job against the match probe
+func (h *healthChecker) observe(line string) {
+	if h.pattern == nil {
+		return
+	}
+
+	if h.pattern.MatchString(line) {
+		h.m.Lock()
+		h.matched = true
+		h.m.Unlock()
+	}
+}
+
+func (h *healthChecker) match() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	matched := h.matched
+	h.matched = false
+
+	if !matched {
+		return fmt.Errorf("no output matched '%s' within %s", h.check.Match, h.interval)
+	}
+
+	return nil
+}
+
+//probe runs the health probe once
+func (h *healthChecker) probe() error {
+	switch {
+	case len(h.check.Exec) > 0:
+		return probeExec(h.table, h.check.Exec, h.timeout)
+	case h.check.TCP != "":
+		return probeDial("tcp", h.check.TCP, h.timeout)
+	case h.check.HTTP != "":
+		return probeHTTP(h.check.HTTP, h.timeout)
+	default:
+		return h.match()
+	}
+}
+
+//report records the outcome of a probe, and tells if the job must be restarted.
+func (h *healthChecker) report(err error) bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.status.LastCheck = time.Now().UnixNano() / int64(time.Millisecond)
+	if err == nil {
+		h.status.Status = core.HealthHealthy
+		h.status.Failures = 0
+		h.status.Error = ""
+		return false
+	}
+
+	h.status.Status = core.HealthUnhealthy
+	h.status.Failures++
+	h.status.Error = err.Error()
+
+	return h.status.Failures >= h.threshold
+}
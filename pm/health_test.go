@@ -0,0 +1,92 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth_NoCheck(t *testing.T) {
+	checker, err := newHealthChecker(nil, nil)
+	if !assert.NoError(t, err) || !assert.Nil(t, checker) {
+		t.Fail()
+	}
+}
+
+func TestHealth_Invalid(t *testing.T) {
+	for _, check := range []*core.HealthCheck{
+		{},
+		{TCP: "localhost:80", HTTP: "http://localhost"},
+		{Match: "("},
+	} {
+		if _, err := newHealthChecker(check, nil); !assert.Error(t, err) {
+			t.Fail()
+		}
+	}
+}
+
+func TestHealth_Threshold(t *testing.T) {
+	checker, _ := newHealthChecker(&core.HealthCheck{Match: "ok", Threshold: 2}, nil)
+	checker.begin()
+
+	if !assert.Equal(t, core.HealthStarting, checker.Status().Status) {
+		t.Fail()
+	}
+
+	checker.observe("all is ok")
+	if !assert.False(t, checker.report(checker.probe())) || !assert.Equal(t, core.HealthHealthy, checker.Status().Status) {
+		t.Fail()
+	}
+
+	//no match since the last probe
+	if !assert.False(t, checker.report(checker.probe())) || !assert.Equal(t, core.HealthUnhealthy, checker.Status().Status) {
+		t.Fail()
+	}
+
+	if !assert.True(t, checker.report(checker.probe())) || !assert.Equal(t, 2, checker.Status().Failures) {
+		t.Fail()
+	}
+
+	checker.begin()
+	if !assert.Equal(t, 0, checker.Status().Failures) {
+		t.Fail()
+	}
+}
+
+func TestHealth_TCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker, _ := newHealthChecker(&core.HealthCheck{TCP: listener.Addr().String()}, nil)
+	if !assert.NoError(t, checker.probe()) {
+		t.Fail()
+	}
+
+	listener.Close()
+	if !assert.Error(t, checker.probe()) {
+		t.Fail()
+	}
+}
+
+func TestHealth_HTTP(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	checker, _ := newHealthChecker(&core.HealthCheck{HTTP: server.URL}, nil)
+	if !assert.NoError(t, checker.probe()) {
+		t.Fail()
+	}
+
+	status = http.StatusServiceUnavailable
+	if !assert.Error(t, checker.probe()) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,244 @@
+package pm
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"github.com/g8os/core.base/pm/core"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	//DefaultHistorySize number of completed jobs kept in history by default
+	DefaultHistorySize = 1000
+
+	//results of the history query commands are not kept in history, otherwise each query
+	//result would embed the results of the previous queries.
+	historyCmdPrefix = "job."
+)
+
+//HistoryFilter filters the jobs history, zero values match all jobs.
+type HistoryFilter struct {
+	Tag     string `json:"tag"`
+	State   string `json:"state"`
+	Command string `json:"command"`
+	//From and To are the range of the job start time (epoch in milliseconds)
+	From  int64 `json:"from"`
+	To    int64 `json:"to"`
+	Limit int   `json:"limit"`
+}
+
+func hasTag(tags string, tag string) bool {
+	fields := strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	for _, t := range fields {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Match checks if the result matches the filter
+func (f *HistoryFilter) Match(result *core.JobResult) bool {
+	if f.Tag != "" && !hasTag(result.Tags, f.Tag) {
+		return false
+	}
+	if f.State != "" && result.State != f.State {
+		return false
+	}
+	if f.Command != "" && result.Command != f.Command {
+		return false
+	}
+	if f.From > 0 && result.StartTime < f.From {
+		return false
+	}
+	if f.To > 0 && result.StartTime > f.To {
+		return false
+	}
+
+	return true
+}
+
+/*
+history is a bounded store of the completed jobs results. Results are optionally persisted in a file (one json
+result per line) so the history survives agent restarts.
+*/
+type history struct {
+	size    int
+	results *list.List
+	byID    map[string]*list.Element
+
+	file    string
+	written int
+
+	m sync.RWMutex
+}
+
+func newHistory(size int) *history {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	return &history{
+		size:    size,
+		results: list.New(),
+		byID:    make(map[string]*list.Element),
+	}
+}
+
+func (h *history) push(result *core.JobResult) {
+	elem := h.results.PushBack(result)
+	//a rejected or duplicate job never ran, its result must not hide the result of the job that has the same ID.
+	if result.State != core.StateRejected && result.State != core.StateDuplicateID {
+		h.byID[result.ID] = elem
+	}
+
+	for h.results.Len() > h.size {
+		front := h.results.Front()
+		old := h.results.Remove(front).(*core.JobResult)
+		if h.byID[old.ID] == front {
+			delete(h.byID, old.ID)
+		}
+	}
+}
+
+//load loads the history file, and enables persistence of new results in that file.
+func (h *history) load(file string) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	f, err := os.Open(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16*1024*1024)
+		for scanner.Scan() {
+			var result core.JobResult
+			if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+				log.Errorf("Discarding corrupt history entry: %s", err)
+				continue
+			}
+			h.push(&result)
+			h.written++
+		}
+
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	h.file = file
+	return nil
+}
+
+//compact rewrites the history file with only the results that are still in history.
+func (h *history) compact() error {
+	tmp := h.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(f)
+	encoder := json.NewEncoder(writer)
+	for e := h.results.Front(); e != nil; e = e.Next() {
+		if err := encoder.Encode(e.Value); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	h.written = h.results.Len()
+	return os.Rename(tmp, h.file)
+}
+
+func (h *history) persist(result *core.JobResult) error {
+	if h.written >= 2*h.size {
+		return h.compact()
+	}
+
+	f, err := os.OpenFile(h.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(result); err != nil {
+		return err
+	}
+
+	h.written++
+	return nil
+}
+
+//Add adds a completed job result to history
+func (h *history) Add(cmd *core.Command, result *core.JobResult) {
+	if strings.HasPrefix(cmd.Command, historyCmdPrefix) {
+		return
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.push(result)
+
+	if h.file == "" {
+		return
+	}
+
+	if err := h.persist(result); err != nil {
+		log.Errorf("Failed to persist history of %s: %s", cmd, err)
+	}
+}
+
+//Get gets the result of the last job with the given id
+func (h *history) Get(id string) (*core.JobResult, bool) {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	elem, ok := h.byID[id]
+	if !ok {
+		return nil, false
+	}
+
+	return elem.Value.(*core.JobResult), true
+}
+
+//List lists the results matching the filter, most recent first.
+func (h *history) List(filter *HistoryFilter) []*core.JobResult {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	results := make([]*core.JobResult, 0)
+	for e := h.results.Back(); e != nil; e = e.Prev() {
+		if filter.Limit > 0 && len(results) >= filter.Limit {
+			break
+		}
+
+		result := e.Value.(*core.JobResult)
+		if filter.Match(result) {
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,119 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func addResult(h *history, id string, command string, state string, tags string, start int64) {
+	h.Add(&core.Command{ID: id, Command: command}, &core.JobResult{
+		ID:        id,
+		Command:   command,
+		State:     state,
+		Tags:      tags,
+		StartTime: start,
+	})
+}
+
+func ids(results []*core.JobResult) []string {
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+
+	return ids
+}
+
+func TestHistory_Bounded(t *testing.T) {
+	h := newHistory(2)
+
+	addResult(h, "1", "core.system", core.StateSuccess, "", 1)
+	addResult(h, "2", "core.system", core.StateSuccess, "", 2)
+	addResult(h, "3", "core.system", core.StateSuccess, "", 3)
+
+	if !assert.Equal(t, []string{"3", "2"}, ids(h.List(&HistoryFilter{}))) {
+		t.Fail()
+	}
+
+	_, ok := h.Get("1")
+	if !assert.False(t, ok) {
+		t.Fail()
+	}
+}
+
+func TestHistory_Duplicate(t *testing.T) {
+	h := newHistory(10)
+
+	addResult(h, "1", "core.system", core.StateSuccess, "", 1)
+	addResult(h, "1", "core.system", core.StateDuplicateID, "", 2)
+
+	//the duplicate is listed, but the result of the job is still the one that ran
+	if !assert.Equal(t, []string{"1", "1"}, ids(h.List(&HistoryFilter{}))) {
+		t.Fail()
+	}
+
+	if result, ok := h.Get("1"); !assert.True(t, ok) || !assert.Equal(t, core.StateSuccess, result.State) {
+		t.Fail()
+	}
+}
+
+func TestHistory_Filter(t *testing.T) {
+	h := newHistory(10)
+
+	addResult(h, "1", "core.system", core.StateSuccess, "build nightly", 100)
+	addResult(h, "2", "core.system", core.StateError, "build", 200)
+	addResult(h, "3", "core.ping", core.StateSuccess, "", 300)
+	addResult(h, "4", "job.list", core.StateSuccess, "", 400)
+
+	if !assert.Equal(t, []string{"2", "1"}, ids(h.List(&HistoryFilter{Tag: "build"}))) {
+		t.Fail()
+	}
+
+	if !assert.Equal(t, []string{"3", "1"}, ids(h.List(&HistoryFilter{State: core.StateSuccess}))) {
+		t.Fail()
+	}
+
+	if !assert.Equal(t, []string{"3"}, ids(h.List(&HistoryFilter{Command: "core.ping"}))) {
+		t.Fail()
+	}
+
+	if !assert.Equal(t, []string{"2"}, ids(h.List(&HistoryFilter{From: 150, To: 250}))) {
+		t.Fail()
+	}
+
+	if !assert.Equal(t, []string{"3"}, ids(h.List(&HistoryFilter{Limit: 1}))) {
+		t.Fail()
+	}
+}
+
+func TestHistory_Persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "history")
+
+	h := newHistory(2)
+	if err := h.load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		addResult(h, id, "core.system", core.StateSuccess, "", 0)
+	}
+
+	loaded := newHistory(2)
+	if err := loaded.load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.Equal(t, []string{"5", "4"}, ids(loaded.List(&HistoryFilter{}))) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,195 @@
+package pm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/g8os/core.base/pm/core"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	journalRunning = "running"
+	journalQueued  = "queued"
+)
+
+/*
+journalEntry is the on-disk record of a single job. Entries under the `running` dir belongs to jobs that
+had a runner, entries under `queued` dir are jobs that are still waiting on a command queue.
+*/
+type journalEntry struct {
+	Seq        int64         `json:"seq"`
+	Route      core.Route    `json:"route"`
+	Command    *core.Command `json:"command"`
+	PID        int           `json:"pid,omitempty"`
+	CreateTime int64         `json:"create_time,omitempty"`
+}
+
+type journalEntries []*journalEntry
+
+func (e journalEntries) Len() int           { return len(e) }
+func (e journalEntries) Less(i, j int) bool { return e[i].Seq < e[j].Seq }
+func (e journalEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+/*
+journal persists the running and queued jobs so they can be recovered after an agent restart. A nil journal
+is valid and does nothing, which is the case when no journal dir is configured.
+*/
+type journal struct {
+	dir string
+	seq int64
+	m   sync.Mutex
+}
+
+func newJournal(dir string) (*journal, error) {
+	for _, sub := range []string{journalRunning, journalQueued} {
+		if err := os.MkdirAll(path.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &journal{dir: dir}, nil
+}
+
+func (j *journal) file(sub string, cmd *core.Command) string {
+	//job ids are set by the controller, so they can't be trusted as file names.
+	return path.Join(j.dir, sub, hex.EncodeToString([]byte(cmd.ID)))
+}
+
+func (j *journal) write(sub string, entry *journalEntry) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if entry.Seq == 0 {
+		seq := time.Now().UnixNano()
+		if seq <= j.seq {
+			seq = j.seq + 1
+		}
+		j.seq = seq
+		entry.Seq = seq
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("Failed to serialize journal entry for %s: %s", entry.Command, err)
+		return
+	}
+
+	//write then rename, so a crash never leaves a half written entry behind.
+	name := j.file(sub, entry.Command)
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		log.Errorf("Failed to write journal entry for %s: %s", entry.Command, err)
+		return
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		log.Errorf("Failed to write journal entry for %s: %s", entry.Command, err)
+	}
+}
+
+func (j *journal) remove(sub string, cmd *core.Command) {
+	if err := os.Remove(j.file(sub, cmd)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to remove journal entry for %s: %s", cmd, err)
+	}
+}
+
+//Queue records a command that is waiting on a command queue
+func (j *journal) Queue(cmd *core.Command) {
+	if j == nil {
+		return
+	}
+
+	j.write(journalQueued, &journalEntry{Route: cmd.Route, Command: cmd})
+}
+
+//Drop forgets about a queued command that never got a runner
+func (j *journal) Drop(cmd *core.Command) {
+	if j == nil {
+		return
+	}
+
+	j.remove(journalQueued, cmd)
+}
+
+//Start records a command that got a runner
+func (j *journal) Start(cmd *core.Command) {
+	if j == nil {
+		return
+	}
+
+	j.write(journalRunning, &journalEntry{Route: cmd.Route, Command: cmd})
+	j.remove(journalQueued, cmd)
+}
+
+//SetPID updates the running command entry with the PID (and creation time) of its process
+func (j *journal) SetPID(cmd *core.Command, pid int, createTime int64) {
+	if j == nil {
+		return
+	}
+
+	j.write(journalRunning, &journalEntry{
+		Route:      cmd.Route,
+		Command:    cmd,
+		PID:        pid,
+		CreateTime: createTime,
+	})
+}
+
+//Done removes the entry of a finished command
+func (j *journal) Done(cmd *core.Command) {
+	if j == nil {
+		return
+	}
+
+	j.remove(journalRunning, cmd)
+}
+
+func (j *journal) load(sub string) (journalEntries, error) {
+	dir := path.Join(j.dir, sub)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(journalEntries, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || path.Ext(info.Name()) == ".tmp" {
+			continue
+		}
+
+		name := path.Join(dir, info.Name())
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Errorf("Failed to read journal entry '%s': %s", name, err)
+			continue
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Command == nil {
+			log.Errorf("Discarding corrupt journal entry '%s': %v", name, err)
+			os.Remove(name)
+			continue
+		}
+
+		entry.Command.Route = entry.Route
+		entries = append(entries, &entry)
+	}
+
+	sort.Sort(entries)
+	return entries, nil
+}
+
+//Load loads the running and queued entries ordered by the time they were journaled
+func (j *journal) Load() (running journalEntries, queued journalEntries, err error) {
+	if running, err = j.load(journalRunning); err != nil {
+		return
+	}
+
+	queued, err = j.load(journalQueued)
+	return
+}
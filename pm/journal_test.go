@@ -0,0 +1,124 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	psutil "github.com/shirou/gopsutil/process"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestJournal_Recover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := newJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := exec.Command("sleep", "30")
+	if err := live.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer live.Process.Kill()
+	//the process is a child of the test, it must be reaped for the adopted process to see it exiting.
+	go live.Wait()
+
+	p, err := psutil.NewProcess(int32(live.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTime, err := p.CreateTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//exiting is adopted, but exits on its own
+	exiting := exec.Command("sleep", "1")
+	if err := exiting.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer exiting.Process.Kill()
+	go exiting.Wait()
+
+	p, err = psutil.NewProcess(int32(exiting.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exitingTime, err := p.CreateTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	j.SetPID(&core.Command{ID: "live", Command: "core.system"}, live.Process.Pid, createTime)
+	j.SetPID(&core.Command{ID: "dead", Command: "core.system"}, dead.Process.Pid, createTime)
+	j.SetPID(&core.Command{ID: "exiting", Command: "core.system"}, exiting.Process.Pid, exitingTime)
+
+	mgr := InitProcessManager(3)
+	results := make(chan *core.JobResult, 3)
+	mgr.AddResultHandler(func(cmd *core.Command, result *core.JobResult) {
+		results <- result
+	})
+
+	if err := mgr.OpenJournal(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	mgr.Recover()
+
+	select {
+	case result := <-results:
+		if !assert.Equal(t, "dead", result.ID) || !assert.Equal(t, core.StateLost, result.State) {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the dead job was not reported lost")
+	}
+
+	mgr.runnersMux.Lock()
+	runner, ok := mgr.runners["live"]
+	mgr.runnersMux.Unlock()
+	if !assert.True(t, ok) {
+		t.Fatal()
+	}
+
+	runner.Kill()
+	result := runner.Wait()
+	if !assert.Equal(t, core.StateKilled, result.State) {
+		t.Fail()
+	}
+
+	//the exit status of an adopted process is unknown
+	states := make(map[string]string)
+	timeout := time.After(5 * time.Second)
+	for len(states) < 2 {
+		select {
+		case result := <-results:
+			states[result.ID] = result.State
+		case <-timeout:
+			t.Fatalf("missing results, got %v", states)
+		}
+	}
+
+	if !assert.Equal(t, map[string]string{"live": core.StateKilled, "exiting": core.StateLost}, states) {
+		t.Fail()
+	}
+
+	//both jobs are done, nothing is left to recover.
+	running, queued, err := j.Load()
+	if !assert.NoError(t, err) || !assert.Empty(t, running) || !assert.Empty(t, queued) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,79 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+)
+
+/*
+Middleware intercepts the commands received by the process manager, it can be used for validation, authorization,
+quotas or auditing. The services are intercepted too each time they are started, except for the boot services.
+*/
+type Middleware interface {
+	//Before is called before the command is accepted. It can change the command (ex: add tags, set limits) or
+	//reject it by returning an error, the command then gets a REJECTED result with the error as data.
+	Before(cmd *core.Command) error
+	//After is called with the result of each command, including the rejected ones.
+	After(cmd *core.Command, result *core.JobResult)
+}
+
+//MiddlewareFunc is a middleware that only intercepts the commands before they run
+type MiddlewareFunc func(cmd *core.Command) error
+
+func (f MiddlewareFunc) Before(cmd *core.Command) error {
+	return f(cmd)
+}
+
+func (f MiddlewareFunc) After(cmd *core.Command, result *core.JobResult) {}
+
+/*
+AddMiddleware adds a middleware to the chain. The middlewares see the commands in the order they were added, and
+the results in the reverse order. A middleware added while the manager is running only sees the commands received
+after it's added.
+*/
+func (pm *PM) AddMiddleware(middleware Middleware) {
+	pm.middlewaresMux.Lock()
+	defer pm.middlewaresMux.Unlock()
+
+	pm.middlewares = append(pm.middlewares, middleware)
+}
+
+//chain gets the current middlewares
+func (pm *PM) chain() []Middleware {
+	pm.middlewaresMux.RLock()
+	defer pm.middlewaresMux.RUnlock()
+
+	return pm.middlewares
+}
+
+/*
+intercept runs the command through the middlewares, and sends a REJECTED result if any of them rejects it. The
+middlewares after the rejecting one don't see the command.
+
+A rejected command never ran, so its result is only listed in the history: it can't be looked up by ID, it's not
+replayed if the command is sent again, and it doesn't release the jobs that run after the same ID.
+*/
+func (pm *PM) intercept(cmd *core.Command) error {
+	for _, middleware := range pm.chain() {
+		if err := middleware.Before(cmd); err != nil {
+			log.Warningf("Command %s was rejected: %s", cmd, err)
+			result := core.NewBasicJobResult(cmd)
+			result.State = core.StateRejected
+			result.Data = err.Error()
+			result.Tags = cmd.Tags
+
+			pm.history.Add(cmd, result)
+			pm.notifyResult(cmd, result)
+			return err
+		}
+	}
+
+	return nil
+}
+
+//observe passes the result through the middlewares
+func (pm *PM) observe(cmd *core.Command, result *core.JobResult) {
+	middlewares := pm.chain()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		middlewares[i].After(cmd, result)
+	}
+}
@@ -0,0 +1,201 @@
+package pm
+
+import (
+	"context"
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMiddleware struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (m *testMiddleware) Before(cmd *core.Command) error {
+	*m.calls = append(*m.calls, "before:"+m.name)
+	cmd.Tags += m.name
+	return m.err
+}
+
+func (m *testMiddleware) After(cmd *core.Command, result *core.JobResult) {
+	*m.calls = append(*m.calls, "after:"+m.name)
+}
+
+func TestMiddleware_Reject(t *testing.T) {
+	mgr := InitProcessManager(1)
+
+	var calls []string
+	mgr.AddMiddleware(&testMiddleware{name: "a", calls: &calls})
+	mgr.AddMiddleware(&testMiddleware{name: "b", calls: &calls, err: fmt.Errorf("not allowed")})
+	mgr.AddMiddleware(&testMiddleware{name: "c", calls: &calls})
+
+	var result *core.JobResult
+	mgr.AddResultHandler(func(cmd *core.Command, r *core.JobResult) {
+		result = r
+	})
+
+	cmd := &core.Command{ID: "job", Command: "core.ping"}
+	if _, err := mgr.RunCmd(cmd); !assert.Error(t, err) {
+		t.Fatal()
+	}
+
+	if !assert.NotNil(t, result) || !assert.Equal(t, core.StateRejected, result.State) ||
+		!assert.Equal(t, "not allowed", result.Data) {
+		t.Fail()
+	}
+
+	//c never saw the command, but all the middlewares see the result.
+	expected := []string{"before:a", "before:b", "after:c", "after:b", "after:a"}
+	if !assert.Equal(t, expected, calls) || !assert.Equal(t, "ab", cmd.Tags) {
+		t.Fail()
+	}
+}
+
+func TestMiddleware_Func(t *testing.T) {
+	mgr := InitProcessManager(1)
+
+	mgr.AddMiddleware(MiddlewareFunc(func(cmd *core.Command) error {
+		if cmd.Command != "core.ping" {
+			return fmt.Errorf("only ping is allowed")
+		}
+		return nil
+	}))
+
+	var result *core.JobResult
+	mgr.AddResultHandler(func(cmd *core.Command, r *core.JobResult) {
+		result = r
+	})
+
+	mgr.PushCmd(&core.Command{ID: "job", Command: "core.system"})
+	if !assert.NotNil(t, result) || !assert.Equal(t, core.StateRejected, result.State) {
+		t.Fail()
+	}
+}
+
+func TestMiddleware_RejectNotCompleted(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.allowed"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		return nil, nil
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.allowed")
+
+	mgr := InitProcessManager(1)
+	mgr.SetIdempotencyWindow(time.Minute)
+
+	rejected := true
+	mgr.AddMiddleware(MiddlewareFunc(func(cmd *core.Command) error {
+		if rejected {
+			return fmt.Errorf("not yet")
+		}
+		return nil
+	}))
+
+	if _, err := mgr.RunCmd(&core.Command{ID: "job", Command: "test.allowed"}); !assert.Error(t, err) {
+		t.Fatal()
+	}
+
+	if !assert.Len(t, mgr.History(&HistoryFilter{State: core.StateRejected}), 1) {
+		t.Fail()
+	}
+
+	//the rejected job never ran, sending it again runs it.
+	rejected = false
+	runner, err := mgr.RunCmd(&core.Command{ID: "job", Command: "test.allowed"})
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	if !assert.Equal(t, core.StateSuccess, runner.Wait().State) {
+		t.Fail()
+	}
+
+	//a retry of the completed job still goes through the middlewares
+	rejected = true
+	if _, err := mgr.RunCmd(&core.Command{ID: "job", Command: "test.allowed"}); !assert.Error(t, err) ||
+		!assert.NotEqual(t, CompletedIDErr, err) {
+		t.Fail()
+	}
+
+	//the rejected retry doesn't hide the result of the job
+	if result, ok := mgr.HistoryResult("job"); !assert.True(t, ok) || !assert.Equal(t, core.StateSuccess, result.State) {
+		t.Fail()
+	}
+}
+
+func TestMiddleware_Services(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.service"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.service")
+
+	mgr := InitProcessManager(2)
+	mgr.AddMiddleware(MiddlewareFunc(func(cmd *core.Command) error {
+		if cmd.ID == "blocked" {
+			return fmt.Errorf("not allowed")
+		}
+		return nil
+	}))
+
+	services := []settings.Startup{
+		settings.Startup{Name: "test.service", RunningDelay: 1}.WithKey("allowed"),
+		settings.Startup{Name: "test.service", RunningDelay: 1}.WithKey("blocked"),
+	}
+	defer mgr.stopServices(context.Background(), services)
+
+	report := mgr.Services().Apply("desired", services, 5*time.Second, false)
+	states := make(map[string]string)
+	for _, service := range report.Boot.Services {
+		states[service.ID] = service.State
+	}
+
+	if !assert.Equal(t, map[string]string{"allowed": BootStarted, "blocked": BootFailed}, states) {
+		t.Fail()
+	}
+
+	if _, ok := mgr.Runner("blocked"); !assert.False(t, ok) {
+		t.Fail()
+	}
+
+	//the boot services don't go through the middlewares
+	boot := mgr.RunSliceReport(settings.StartupSlice{services[1]}, 5*time.Second)
+	if !assert.True(t, boot.OK()) {
+		t.Fail()
+	}
+}
+
+func TestMiddleware_AddRunning(t *testing.T) {
+	mgr := InitProcessManager(1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			mgr.AddMiddleware(MiddlewareFunc(func(cmd *core.Command) error {
+				return nil
+			}))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if !assert.NoError(t, mgr.intercept(&core.Command{ID: "job"})) {
+			t.Fatal()
+		}
+	}
+
+	wg.Wait()
+	if !assert.Len(t, mgr.chain(), 100) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,66 @@
+package pm
+
+import (
+	"container/heap"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"strings"
+)
+
+type pendingCmd struct {
+	cmd *core.Command
+	seq uint64
+}
+
+/*
+pendingCmds is a priority queue of the commands waiting for a free job slot. Commands with higher
+priority come first, commands with the same priority keep their arrival order.
+*/
+type pendingCmds struct {
+	cmds []*pendingCmd
+	seq  uint64
+}
+
+func (p *pendingCmds) Len() int {
+	return len(p.cmds)
+}
+
+func (p *pendingCmds) Less(i, j int) bool {
+	a, b := p.cmds[i], p.cmds[j]
+	if a.cmd.Priority != b.cmd.Priority {
+		return a.cmd.Priority > b.cmd.Priority
+	}
+
+	return a.seq < b.seq
+}
+
+func (p *pendingCmds) Swap(i, j int) {
+	p.cmds[i], p.cmds[j] = p.cmds[j], p.cmds[i]
+}
+
+func (p *pendingCmds) Push(x interface{}) {
+	p.cmds = append(p.cmds, x.(*pendingCmd))
+}
+
+func (p *pendingCmds) Pop() interface{} {
+	n := len(p.cmds)
+	x := p.cmds[n-1]
+	p.cmds = p.cmds[:n-1]
+	return x
+}
+
+//push adds a command to the pending set
+func (p *pendingCmds) push(cmd *core.Command) {
+	p.seq++
+	heap.Push(p, &pendingCmd{cmd: cmd, seq: p.seq})
+}
+
+//pop gets the pending command with the highest priority
+func (p *pendingCmds) pop() *core.Command {
+	return heap.Pop(p).(*pendingCmd).cmd
+}
+
+//isBuiltin checks if the command is a builtin core.* command
+func isBuiltin(cmd *core.Command) bool {
+	return strings.HasPrefix(cmd.Command, "core.") && cmd.Command != process.CommandSystem
+}
@@ -0,0 +1,27 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPending_Priority(t *testing.T) {
+	pending := &pendingCmds{}
+
+	pending.push(&core.Command{ID: "bulk-1"})
+	pending.push(&core.Command{ID: "urgent", Priority: 10})
+	pending.push(&core.Command{ID: "bulk-2"})
+	pending.push(&core.Command{ID: "low", Priority: -1})
+	pending.push(&core.Command{ID: "high", Priority: 5})
+
+	expected := []string{"urgent", "high", "bulk-1", "bulk-2", "low"}
+	actual := make([]string, 0)
+	for pending.Len() > 0 {
+		actual = append(actual, pending.pop().ID)
+	}
+
+	if !assert.Equal(t, expected, actual) {
+		t.Fatal()
+	}
+}
@@ -1,6 +1,7 @@
 package pm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/g8os/core.base/pm/core"
@@ -24,6 +25,18 @@ var (
 	log               = logging.MustGetLogger("pm")
 	UnknownCommandErr = errors.New("unkonw command")
 	DuplicateIDErr    = errors.New("duplicate job id")
+	CompletedIDErr    = errors.New("job id recently completed")
+	ShuttingDownErr   = errors.New("process manager is shutting down")
+	CancelledErr      = errors.New("job was cancelled")
+)
+
+const (
+	//DefaultAfterTimeout is how long a command waits for the jobs it runs after, unless it sets its own timeout
+	DefaultAfterTimeout = 1 * time.Hour
+
+	//jobStatesWindow is how long the state of a completed job is kept for the commands that run after it, the
+	//older jobs are looked up in the history.
+	jobStatesWindow = 10 * time.Minute
 )
 
 //MeterHandler represents a callback type
@@ -43,8 +56,10 @@ type StatsFlushHandler func(stats *stats.Stats)
 //PM is the main process manager.
 type PM struct {
 	midMux  sync.Mutex
-	cmds    chan *core.Command
+	pending *pendingCmds
 	runners map[string]Runner
+	//awaited are the commands pushed with PushCmdWait, guarded by the runners lock
+	awaited map[*core.Command]*awaitedCmd
 
 	runnersMux sync.Mutex
 
@@ -57,9 +72,26 @@ type PM struct {
 	routeResultHandlers map[core.Route][]ResultHandler
 	statsFlushHandlers  []StatsFlushHandler
 	queueMgr            *cmdQueueManager
+	jobStates           StateMachine
+
+	history        *history
+	completed      *completedJobs
+	journal        *journal
+	journalRunning journalEntries
+	journalQueued  journalEntries
 
-	pids    map[int]chan *syscall.WaitStatus
+	pids    map[int]chan *process.ProcessState
 	pidsMux sync.Mutex
+
+	services       *ServiceRegistry
+	middlewares    []Middleware
+	middlewaresMux sync.RWMutex
+
+	//closing is set once the shutdown starts, no more commands are accepted after that.
+	closing int32
+	//done is cancelled once the shutdown starts, the commands waiting for their dependencies give up then.
+	done   context.Context
+	cancel context.CancelFunc
 }
 
 var pm *PM
@@ -67,8 +99,9 @@ var pm *PM
 //NewPM creates a new PM
 func InitProcessManager(maxJobs int) *PM {
 	pm = &PM{
-		cmds:     make(chan *core.Command),
+		pending:  &pendingCmds{},
 		runners:  make(map[string]Runner),
+		awaited:  make(map[*core.Command]*awaitedCmd),
 		maxJobs:  maxJobs,
 		jobsCond: sync.NewCond(&sync.Mutex{}),
 
@@ -77,10 +110,16 @@ func InitProcessManager(maxJobs int) *PM {
 		routeResultHandlers: make(map[core.Route][]ResultHandler),
 		statsFlushHandlers:  make([]StatsFlushHandler, 0, 3),
 		queueMgr:            newCmdQueueManager(),
+		jobStates:           NewStateMachine(),
+		history:             newHistory(DefaultHistorySize),
+		completed:           newCompletedJobs(0),
 
-		pids: make(map[int]chan *syscall.WaitStatus),
+		pids: make(map[int]chan *process.ProcessState),
 	}
 
+	pm.services = newServiceRegistry(pm)
+	pm.done, pm.cancel = context.WithCancel(context.Background())
+
 	log.Infof("Process manager intialization completed")
 	return pm
 }
@@ -112,9 +151,110 @@ func saveMid(midfile string, mid uint32) {
 	ioutil.WriteFile(midfile, []byte(fmt.Sprintf("%d", mid)), 0644)
 }
 
-//RunCmd runs and manage command
+/*
+PushCmd adds the command to the pending commands, it will run as soon as there is a free job slot. Pending
+commands are executed in order of priority.
+
+If the command runs after other jobs, it's held until those jobs are done.
+*/
 func (pm *PM) PushCmd(cmd *core.Command) {
-	pm.cmds <- cmd
+	if pm.rejectClosing(cmd) || pm.intercept(cmd) != nil || pm.replay(cmd) {
+		return
+	}
+
+	pm.afterDependencies(cmd, pm.pushPending)
+}
+
+/*
+afterDependencies calls next with the command once all the jobs it runs after are done. If one of them
+failed, the command is terminated with a DEPENDENCY_FAILED state, unless it's set to run anyway. A command
+that waits longer than its after timeout is terminated with a DEPENDENCY_FAILED state as well.
+*/
+func (pm *PM) afterDependencies(cmd *core.Command, next func(*core.Command)) {
+	if len(cmd.After) == 0 {
+		next(cmd)
+		return
+	}
+
+	timeout := DefaultAfterTimeout
+	if cmd.AfterTimeout > 0 {
+		timeout = time.Duration(cmd.AfterTimeout) * time.Second
+	}
+
+	//the states of the jobs that completed a while ago are expired, their results are still in the history.
+	_, pending := pm.jobStates.Blocking(cmd.After...)
+	for _, id := range pending {
+		pm.runnersMux.Lock()
+		_, running := pm.runners[id]
+		pm.runnersMux.Unlock()
+
+		if running {
+			continue
+		}
+
+		if result, ok := pm.history.Get(id); ok {
+			pm.jobStates.Release(id, result.State == core.StateSuccess)
+		}
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(pm.done, timeout)
+		defer cancel()
+
+		log.Debugf("Waiting for %s to run %s", cmd.After, cmd)
+		ok, err := pm.jobStates.WaitContext(ctx, cmd.After...)
+
+		result := core.NewBasicJobResult(cmd)
+		result.State = core.StateDependencyFailed
+
+		switch {
+		case err != nil && pm.ShuttingDown():
+			pm.cancelCmd(cmd)
+			return
+		case err != nil:
+			_, pending := pm.jobStates.Blocking(cmd.After...)
+			log.Errorf("Can't start %s because of a timeout waiting for %v", cmd, pending)
+			result.Data = fmt.Sprintf("timed out waiting for the dependencies %v", pending)
+		case ok || cmd.AfterFailure == core.AfterFailureRun:
+			next(cmd)
+			return
+		default:
+			log.Errorf("Can't start %s because one of the dependencies failed", cmd)
+			result.Data = fmt.Sprintf("one of the dependencies %s failed", cmd.After)
+		}
+
+		pm.resultCallback(cmd, result)
+	}()
+}
+
+//expireJobStates forgets the states of the jobs that completed before the jobs states window
+func (pm *PM) expireJobStates() {
+	for range time.Tick(time.Minute) {
+		window := jobStatesWindow
+		if pm.completed.window > window {
+			window = pm.completed.window
+		}
+
+		pm.jobStates.Expire(time.Now().Add(-window))
+	}
+}
+
+func (pm *PM) pushPending(cmd *core.Command) {
+	if pm.rejectClosing(cmd) {
+		return
+	}
+
+	if isUnlimited(cmd) || settings.Settings.Main.BuiltinBypass && isBuiltin(cmd) {
+		//builtin commands are not limited by the max jobs.
+		go pm.runCmd(cmd)
+		return
+	}
+
+	pm.jobsCond.L.Lock()
+	defer pm.jobsCond.L.Unlock()
+
+	pm.pending.push(cmd)
+	pm.jobsCond.Broadcast()
 }
 
 /*
@@ -124,6 +264,18 @@ other commands runs on the same queue.
 The queue name is retrieved from cmd.Args[queue]
 */
 func (pm *PM) PushCmdToQueue(cmd *core.Command) {
+	if pm.rejectClosing(cmd) || pm.intercept(cmd) != nil || pm.replay(cmd) {
+		return
+	}
+
+	pm.afterDependencies(cmd, pm.pushQueued)
+}
+
+func (pm *PM) pushQueued(cmd *core.Command) {
+	if pm.rejectClosing(cmd) {
+		return
+	}
+
 	pm.queueMgr.Push(cmd)
 }
 
@@ -156,20 +308,68 @@ func (pm *PM) NewRunner(cmd *core.Command, factory process.ProcessFactory, hooks
 		return nil, DuplicateIDErr
 	}
 
+	if pm.cancelled(cmd) {
+		return nil, CancelledErr
+	}
+
 	runner := NewRunner(pm, cmd, factory, hooks...)
 	pm.runners[cmd.ID] = runner
+	pm.journal.Start(cmd)
 
 	go runner.Run()
 
 	return runner, nil
 }
 
+/*
+RunCmd runs the command right away, regardless of the free job slots. The command goes through the middlewares
+first.
+*/
 func (pm *PM) RunCmd(cmd *core.Command, hooks ...RunnerHook) (Runner, error) {
+	if pm.rejectClosing(cmd) {
+		return nil, ShuttingDownErr
+	}
+
+	if err := pm.intercept(cmd); err != nil {
+		return nil, err
+	}
+
+	if pm.replay(cmd) {
+		return nil, CompletedIDErr
+	}
+
+	return pm.runCmd(cmd, hooks...)
+}
+
+/*
+replay sends back the result of the command if a job with the same ID was completed within the idempotency
+window, probably a retry from the controller. It's only checked for the commands received by the manager, the
+agent reuses the IDs of its own jobs (like restarting a service). It's checked after the middlewares, so a
+retry that is not allowed anymore doesn't get the result of the completed job.
+*/
+func (pm *PM) replay(cmd *core.Command) bool {
+	result, ok := pm.completed.Get(cmd.ID)
+	if !ok {
+		return false
+	}
+
+	log.Infof("Job id '%s' was recently completed, replaying its result", cmd.ID)
+	pm.notifyResult(cmd, result)
+	return true
+}
+
+//runCmd runs a command that was already accepted (or that is started by the agent itself, like the services).
+func (pm *PM) runCmd(cmd *core.Command, hooks ...RunnerHook) (Runner, error) {
+	if pm.rejectClosing(cmd) {
+		return nil, ShuttingDownErr
+	}
+
 	factory := GetProcessFactory(cmd)
 	if factory == nil {
 		log.Errorf("Unknow command '%s'", cmd.Command)
 		errResult := core.NewBasicJobResult(cmd)
 		errResult.State = core.StateUnknownCmd
+		pm.dropCmd(cmd)
 		pm.resultCallback(cmd, errResult)
 		return nil, UnknownCommandErr
 	}
@@ -181,12 +381,22 @@ func (pm *PM) RunCmd(cmd *core.Command, hooks ...RunnerHook) (Runner, error) {
 		errResult := core.NewBasicJobResult(cmd)
 		errResult.State = core.StateDuplicateID
 		errResult.Data = err.Error()
+		pm.dropCmd(cmd)
+		pm.resultCallback(cmd, errResult)
+		return nil, err
+	} else if err == CancelledErr {
+		log.Infof("Not starting %s, it was cancelled", cmd)
+		errResult := core.NewBasicJobResult(cmd)
+		errResult.State = core.StateCancelled
+		errResult.Data = err.Error()
+		pm.dropCmd(cmd)
 		pm.resultCallback(cmd, errResult)
 		return nil, err
 	} else if err != nil {
 		errResult := core.NewBasicJobResult(cmd)
 		errResult.State = core.StateError
 		errResult.Data = err.Error()
+		pm.dropCmd(cmd)
 		pm.resultCallback(cmd, errResult)
 		return nil, err
 	}
@@ -194,55 +404,86 @@ func (pm *PM) RunCmd(cmd *core.Command, hooks ...RunnerHook) (Runner, error) {
 	return runner, nil
 }
 
+//dropCmd forgets about a command that never got a runner
+func (pm *PM) dropCmd(cmd *core.Command) {
+	pm.journal.Drop(cmd)
+	//release the queue slot (if any) without blocking the commands processing.
+	go pm.queueMgr.Notify(cmd)
+}
+
+func (pm *PM) processQueues() {
+	//cmds that were waiting on a queue are ready to run, they still have
+	//to wait for a free job slot.
+	for cmd := range pm.queueMgr.Producer() {
+		pm.pushPending(cmd)
+	}
+}
+
 func (pm *PM) processCmds() {
 	for {
 		pm.jobsCond.L.Lock()
 
-		for len(pm.runners) >= pm.maxJobs {
+		for pm.pending.Len() == 0 || pm.runningCount() >= pm.maxJobs {
 			pm.jobsCond.Wait()
 		}
+
+		cmd := pm.pending.pop()
 		pm.jobsCond.L.Unlock()
 
-		var cmd *core.Command
+		pm.runCmd(cmd)
+	}
+}
+
+//runningCount gets the number of jobs that have a runner
+//runningCount gets the number of running jobs that hold a job slot
+func (pm *PM) runningCount() int {
+	pm.runnersMux.Lock()
+	defer pm.runnersMux.Unlock()
 
-		//we have 2 possible sources of cmds.
-		//1- cmds that doesn't require waiting on a queue, those can run immediately
-		//2- cmds that were waiting on a queue (so they must execute serially)
-		select {
-		case cmd = <-pm.cmds:
-		case cmd = <-pm.queueMgr.Producer():
+	count := 0
+	for _, runner := range pm.runners {
+		if !isUnlimited(runner.Command()) {
+			count++
 		}
-
-		pm.RunCmd(cmd)
 	}
+
+	return count
 }
 
 func (pm *PM) processWait() {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGCHLD)
 	for _ = range ch {
-		var status syscall.WaitStatus
-		var rusage syscall.Rusage
+		//SIGCHLD signals coalesce, so reap all the exited children.
+		for {
+			state := &process.ProcessState{}
+
+			pid, err := syscall.Wait4(-1, &state.Status, syscall.WNOHANG, &state.Rusage)
+			if err != nil {
+				if err != syscall.ECHILD {
+					log.Errorf("Wait error: %s", err)
+				}
+				break
+			}
 
-		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, &rusage)
-		if err != nil {
-			log.Errorf("Wait error: %s", err)
-			continue
-		}
+			if pid <= 0 {
+				break
+			}
 
-		//Avoid reading the process state before the Register call is complete.
-		pm.pidsMux.Lock()
-		ch, ok := pm.pids[pid]
-		pm.pidsMux.Unlock()
-
-		if ok {
-			go func() {
-				ch <- &status
-				close(ch)
-				pm.pidsMux.Lock()
-				defer pm.pidsMux.Unlock()
-				delete(pm.pids, pid)
-			}()
+			//Avoid reading the process state before the Register call is complete.
+			pm.pidsMux.Lock()
+			ch, ok := pm.pids[pid]
+			pm.pidsMux.Unlock()
+
+			if ok {
+				go func(pid int) {
+					ch <- state
+					close(ch)
+					pm.pidsMux.Lock()
+					defer pm.pidsMux.Unlock()
+					delete(pm.pids, pid)
+				}(pid)
+			}
 		}
 	}
 }
@@ -255,21 +496,162 @@ func (pm *PM) Register(g process.GetPID) error {
 		return err
 	}
 
-	ch := make(chan *syscall.WaitStatus)
+	ch := make(chan *process.ProcessState)
 	pm.pids[pid] = ch
 
 	return nil
 }
 
-func (pm *PM) WaitPID(pid int) *syscall.WaitStatus {
+func (pm *PM) WaitPID(pid int) *process.ProcessState {
 	return <-pm.pids[pid]
 }
 
+/*
+SetIdempotencyWindow sets how long the results of the completed jobs are remembered. A command received
+within that window with the ID of a completed job is not executed again, and the stored result is sent back
+instead. A zero window (default) disables it.
+*/
+func (pm *PM) SetIdempotencyWindow(window time.Duration) {
+	pm.completed = newCompletedJobs(window)
+}
+
+/*
+SetHistory sets the size of the completed jobs history. If file is set, the history is loaded from that file
+and new results are persisted in it.
+*/
+func (pm *PM) SetHistory(size int, file string) error {
+	h := newHistory(size)
+	if file != "" {
+		if err := h.load(file); err != nil {
+			return err
+		}
+	}
+
+	pm.history = h
+	return nil
+}
+
+//History lists the completed jobs results matching the filter, most recent first.
+func (pm *PM) History(filter *HistoryFilter) []*core.JobResult {
+	return pm.history.List(filter)
+}
+
+//HistoryResult gets the result of a completed job by the cmd ID
+func (pm *PM) HistoryResult(cmdID string) (*core.JobResult, bool) {
+	return pm.history.Get(cmdID)
+}
+
+/*
+OpenJournal enables the on-disk job journal under dir. Running and queued jobs are recorded in the journal
+so they can survive an agent restart. Jobs that were journaled by a previous run of the agent are loaded, and
+are recovered on calling Recover.
+*/
+func (pm *PM) OpenJournal(dir string) error {
+	j, err := newJournal(dir)
+	if err != nil {
+		return err
+	}
+
+	running, queued, err := j.Load()
+	if err != nil {
+		return err
+	}
+
+	pm.journal = j
+	pm.queueMgr.journal = j
+	pm.journalRunning = running
+	pm.journalQueued = queued
+
+	return nil
+}
+
+func (pm *PM) adopt(entry *journalEntry) error {
+	cmd := entry.Command
+	factory := GetProcessFactory(cmd)
+	if factory == nil {
+		return UnknownCommandErr
+	}
+
+	p, err := psutil.NewProcess(int32(entry.PID))
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Kill(entry.PID, 0); err != nil && err != syscall.EPERM {
+		return err
+	}
+
+	//make sure the pid wasn't reused by another process.
+	if createTime, err := p.CreateTime(); err != nil {
+		return err
+	} else if createTime != entry.CreateTime {
+		return fmt.Errorf("pid %d was reused by another process", entry.PID)
+	}
+
+	pm.runnersMux.Lock()
+	defer pm.runnersMux.Unlock()
+
+	if _, exists := pm.runners[cmd.ID]; exists {
+		return DuplicateIDErr
+	}
+
+	runner := NewRunner(pm, cmd, process.NewAdoptedProcessFactory(entry.PID, factory))
+	pm.runners[cmd.ID] = runner
+	pm.journal.SetPID(cmd, entry.PID, entry.CreateTime)
+
+	go runner.Run()
+
+	return nil
+}
+
+/*
+Recover recovers the jobs loaded by OpenJournal. Running jobs that still have a live process are adopted,
+queued jobs are pushed again to their queues in the same order, and all other jobs are reported with a
+LOST state.
+
+Recover should be called after the result handlers are registered, otherwise the LOST results won't reach
+the controller.
+*/
+func (pm *PM) Recover() {
+	running, queued := pm.journalRunning, pm.journalQueued
+	pm.journalRunning, pm.journalQueued = nil, nil
+
+	for _, entry := range running {
+		cmd := entry.Command
+		var err error
+		if entry.PID == 0 {
+			err = fmt.Errorf("job has no process to adopt")
+		} else {
+			err = pm.adopt(entry)
+		}
+
+		if err == nil {
+			log.Infof("Adopted process %d of %s", entry.PID, cmd)
+			continue
+		}
+
+		log.Warningf("Job %s was lost during agent restart: %s", cmd, err)
+		pm.journal.Done(cmd)
+
+		result := core.NewBasicJobResult(cmd)
+		result.State = core.StateLost
+		result.Data = fmt.Sprintf("job was lost during agent restart: %s", err)
+		pm.resultCallback(cmd, result)
+	}
+
+	for _, entry := range queued {
+		log.Infof("Re-queuing %s on queue '%s'", entry.Command, entry.Command.Queue)
+		pm.queueMgr.Push(entry.Command)
+	}
+}
+
 //Run starts the process manager.
 func (pm *PM) Run() {
 	//process and start all commands according to args.
 	go pm.processWait()
+	go pm.processQueues()
 	go pm.processCmds()
+	go pm.expireJobStates()
 }
 
 /*
@@ -278,10 +660,43 @@ start in order, but will also make sure a service won't start until it's depende
 running.
 */
 func (pm *PM) RunSlice(slice settings.StartupSlice) {
+	pm.RunSliceReport(slice, 0)
+}
+
+/*
+RunSliceReport runs the slice like RunSlice. If timeout is not zero, it gives up waiting after timeout, the
+services that are still waiting for their dependencies are skipped. It returns a report of what happened to
+each service.
+*/
+func (pm *PM) RunSliceReport(slice settings.StartupSlice, timeout time.Duration) *BootReport {
+	//disabled services are not started on boot. The boot services are the agent's own, they don't go through
+	//the middlewares, which are usually not added yet anyway.
+	return pm.runSlice(slice, timeout, pm.services.Disabled, false)
+}
+
+/*
+runSlice runs the slice, the services for which skip returns true are not started. If intercept is set, each
+service goes through the middlewares before it starts, like the commands received by the manager: a rejected
+service fails.
+*/
+func (pm *PM) runSlice(slice settings.StartupSlice, timeout time.Duration, skip func(string) bool, intercept bool) *BootReport {
+	begin := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer cancel()
+
+	//the services hooks can still release their state once the slice is booted, they are ignored then.
 	state := NewStateMachine()
+	defer state.Stop()
+
 	provided := make(map[string]int)
 	needed := make(map[string]int)
 	all := make([]string, 0)
+	report := newBootReport()
+
+	var wg sync.WaitGroup
 
 	for _, startup := range slice {
 		if startup.Args == nil {
@@ -292,6 +707,7 @@ func (pm *PM) RunSlice(slice settings.StartupSlice) {
 			ID:        startup.Key(),
 			Command:   startup.Name,
 			Arguments: core.MustArguments(startup.Args),
+			Health:    startup.Health,
 		}
 
 		all = append(all, cmd.ID)
@@ -301,50 +717,122 @@ func (pm *PM) RunSlice(slice settings.StartupSlice) {
 			needed[k] = 1
 		}
 
-		go func(up settings.Startup, c *core.Command) {
-			log.Debugf("Waiting for %s to run %s", up.After, cmd)
-			canRun := state.Wait(up.After...)
-
-			if canRun {
-				log.Infof("Starting %s", c)
-				var hooks []RunnerHook
-
-				if up.RunningMatch != "" {
-					//NOTE: If runner match is provided it take presence over the delay
-					hooks = append(hooks, &MatchHook{
-						Match: up.RunningMatch,
-						Action: func(msg *stream.Message) {
-							log.Infof("Got '%s' from '%s' signal running", msg.Message, c.ID)
-							state.Release(c.ID, true)
-						},
-					})
-				} else if up.RunningDelay >= 0 {
-					d := 2 * time.Second
-					if up.RunningDelay > 0 {
-						d = time.Duration(up.RunningDelay) * time.Second
-					}
+		//each routine only touches the report of its own service, the report is read after they are all done.
+		service := &ServiceReport{ID: cmd.ID}
+		report.Services = append(report.Services, service)
+
+		wg.Add(1)
+		go func(up settings.Startup, c *core.Command, service *ServiceReport) {
+			defer wg.Done()
+
+			if skip != nil && skip(c.ID) {
+				log.Infof("Not starting %s, the service is disabled", c)
+				service.State = BootDisabled
+				state.Release(c.ID, false)
+				return
+			}
+
+			log.Debugf("Waiting for %s to run %s", up.After, c)
+			canRun, err := state.WaitContext(ctx, up.After...)
+
+			if err != nil {
+				_, pending := state.Blocking(up.After...)
+				log.Errorf("Can't start %s because of a timeout waiting for %v", c, pending)
+				service.State = BootSkipped
+				service.BlockedBy = pending
+				service.Error = err.Error()
+				state.Release(c.ID, false)
+				return
+			}
+
+			if !canRun {
+				failed, _ := state.Blocking(up.After...)
+				log.Errorf("Can't start %s because one of the dependencies failed", c)
+				service.State = BootSkipped
+				service.BlockedBy = failed
+				state.Release(c.ID, false)
+				return
+			}
 
-					hook := &DelayHook{
-						Delay: d,
-						Action: func() {
-							state.Release(c.ID, true)
-						},
+			if intercept {
+				if err := pm.intercept(c); err != nil {
+					service.State = BootFailed
+					service.Error = err.Error()
+					state.Release(c.ID, false)
+					return
+				}
+			}
+
+			log.Infof("Starting %s", c)
+			var hooks []RunnerHook
+
+			//started gets the runner of this service, so a late readiness timeout never kills a newer job
+			//that reuses the service ID.
+			started := make(chan Runner, 1)
+
+			if up.Ready != nil {
+				hook, err := NewReadinessHook(up.Ready, pm, func(ready bool) {
+					if !ready {
+						log.Errorf("%s is not ready after %d seconds, stopping it", c, up.Ready.Timeout)
+						state.Release(c.ID, false)
+						if runner := <-started; runner != nil {
+							runner.Kill()
+						}
+						return
 					}
-					hooks = append(hooks, hook)
+
+					log.Infof("%s is ready", c)
+					state.Release(c.ID, true)
+				})
+
+				if err != nil {
+					log.Errorf("Can't start %s: %s", c, err)
+					service.State = BootFailed
+					service.Error = err.Error()
+					state.Release(c.ID, false)
+					return
 				}
 
-				hooks = append(hooks, &ExitHook{
-					Action: func(s bool) {
-						state.Release(c.ID, s)
+				hooks = append(hooks, hook)
+			} else if up.RunningMatch != "" {
+				//NOTE: If runner match is provided it take presence over the delay
+				hooks = append(hooks, &MatchHook{
+					Match: up.RunningMatch,
+					Action: func(msg *stream.Message) {
+						log.Infof("Got '%s' from '%s' signal running", msg.Message, c.ID)
+						state.Release(c.ID, true)
 					},
 				})
+			} else if up.RunningDelay >= 0 {
+				d := 2 * time.Second
+				if up.RunningDelay > 0 {
+					d = time.Duration(up.RunningDelay) * time.Second
+				}
 
-				pm.RunCmd(c, hooks...)
+				hook := &DelayHook{
+					Delay: d,
+					Action: func() {
+						state.Release(c.ID, true)
+					},
+				}
+				hooks = append(hooks, hook)
+			}
 
-			} else {
-				log.Errorf("Can't start %s because one of the dependencies failed", c)
+			hooks = append(hooks, &ExitHook{
+				Action: func(s bool) {
+					state.Release(c.ID, s)
+				},
+			})
+
+			runner, err := pm.runCmd(c, hooks...)
+			started <- runner
+			if err != nil {
+				log.Errorf("Can't start %s: %s", c, err)
+				service.State = BootFailed
+				service.Error = err.Error()
+				state.Release(c.ID, false)
 			}
-		}(startup, cmd)
+		}(startup, cmd, service)
 	}
 	//release all dependencies that are not provided by this slice.
 	for k := range needed {
@@ -356,7 +844,35 @@ func (pm *PM) RunSlice(slice settings.StartupSlice) {
 
 	//wait for the full slice to run
 	log.Infof("Waiting for the slice to boot")
-	state.Wait(all...)
+	if _, err := state.WaitContext(ctx, all...); err != nil {
+		log.Errorf("Timed out waiting for the slice to boot")
+		report.TimedOut = true
+	}
+
+	//once the slice is booted (or timed out) all the routines are past waiting.
+	wg.Wait()
+
+	for _, service := range report.Services {
+		if service.State != "" {
+			continue
+		}
+
+		failed, pending := state.Blocking(service.ID)
+		switch {
+		case len(failed) > 0:
+			service.State = BootFailed
+		case len(pending) > 0:
+			service.State = BootTimeout
+		default:
+			service.State = BootStarted
+		}
+	}
+
+	report.done(begin)
+	log.Infof("Slice booted in %.1fs: %d started, %d failed, %d skipped, %d timed out", report.Duration,
+		report.Count(BootStarted), report.Count(BootFailed), report.Count(BootSkipped), report.Count(BootTimeout))
+
+	return report
 }
 
 func (pm *PM) cleanUp(runner Runner) {
@@ -364,13 +880,32 @@ func (pm *PM) cleanUp(runner Runner) {
 	delete(pm.runners, runner.Command().ID)
 	pm.runnersMux.Unlock()
 
+	pm.journal.Done(runner.Command())
+
 	pm.queueMgr.Notify(runner.Command())
 	pm.jobsCond.Broadcast()
 }
 
-//Processes returs a list of running processes
+//Runners gets a snapshot of the running processes, the jobs can start and exit while it's used.
 func (pm *PM) Runners() map[string]Runner {
-	return pm.runners
+	pm.runnersMux.Lock()
+	defer pm.runnersMux.Unlock()
+
+	runners := make(map[string]Runner, len(pm.runners))
+	for id, runner := range pm.runners {
+		runners[id] = runner
+	}
+
+	return runners
+}
+
+//Runner gets the runner of the running job with the given id
+func (pm *PM) Runner(id string) (Runner, bool) {
+	pm.runnersMux.Lock()
+	defer pm.runnersMux.Unlock()
+
+	runner, ok := pm.runners[id]
+	return runner, ok
 }
 
 //Killall kills all running processes.
@@ -385,9 +920,16 @@ func (pm *PM) Killall() {
 
 //Kill kills a process by the cmd ID
 func (pm *PM) Kill(cmdID string) {
-	v, o := pm.runners[cmdID]
-	if o {
-		v.Kill()
+	if runner, ok := pm.Runner(cmdID); ok {
+		runner.Kill()
+	}
+}
+
+//Terminate sends sig to a process by the cmd ID, and escalates to SIGKILL if it didn't exit within grace.
+//zero values fall back to the command kill signal and grace period.
+func (pm *PM) Terminate(cmdID string, sig syscall.Signal, grace time.Duration) {
+	if runner, ok := pm.Runner(cmdID); ok {
+		runner.Terminate(sig, grace)
 	}
 }
 
@@ -408,6 +950,21 @@ func (pm *PM) resultCallback(cmd *core.Command, result *core.JobResult) {
 	result.Tags = cmd.Tags
 	//NOTE: we always force the real gid and nid on the result.
 
+	pm.history.Add(cmd, result)
+	pm.completed.Add(result)
+
+	if cmd.ID != "" && result.State != core.StateDuplicateID {
+		//unblock the jobs that run after this one.
+		pm.jobStates.Release(cmd.ID, result.State == core.StateSuccess)
+	}
+
+	pm.notifyResult(cmd, result)
+}
+
+func (pm *PM) notifyResult(cmd *core.Command, result *core.JobResult) {
+	pm.observe(cmd, result)
+	pm.deliver(cmd, result)
+
 	for _, handler := range pm.resultHandlers {
 		handler(cmd, result)
 	}
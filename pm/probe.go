@@ -0,0 +1,69 @@
+package pm
+
+import (
+	"fmt"
+	"github.com/g8os/core.base/pm/process"
+	"net"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+//probeExec runs the probe command, the probe succeeds if the command exits with 0 within the timeout.
+func probeExec(table process.PIDTable, args []string, timeout time.Duration) error {
+	cmd := exec.Command(args[0], args[1:]...)
+
+	//the PM reaps all the children, so the probe exit status must be collected through the PM as well.
+	err := table.Register(func() (int, error) {
+		if err := cmd.Start(); err != nil {
+			return 0, err
+		}
+		return cmd.Process.Pid, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan *process.ProcessState, 1)
+	go func() {
+		exited <- table.WaitPID(cmd.Process.Pid)
+	}()
+
+	select {
+	case state := <-exited:
+		if !state.Status.Exited() || state.Status.ExitStatus() != 0 {
+			return fmt.Errorf("probe exited with status %d", state.Status.ExitStatus())
+		}
+		return nil
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("probe timed out")
+	}
+}
+
+//probeDial checks that the address (tcp host:port, or unix socket path) accepts connections
+func probeDial(network string, address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+//probeHTTP checks that the url answers a GET with a status below 400
+func probeHTTP(url string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package process
+
+import (
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/stream"
+	psutils "github.com/shirou/gopsutil/process"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	adoptedPollPeriod = 1 * time.Second
+)
+
+/*
+adoptedProcessImpl represents a process that was started by a previous instance of the agent, and that
+survived the agent restart. Since the agent is not the parent of that process anymore, the outputs can't be
+captured and the exit status can't be collected, we can only monitor, measure and kill it.
+*/
+type adoptedProcessImpl struct {
+	cmd *core.Command
+	pid int
+
+	//process is set by Run, and read by the runner loop (Signal, Kill) and the stats meter.
+	process *psutils.Process
+	m       sync.Mutex
+}
+
+func NewAdoptedProcess(pid int, cmd *core.Command) Process {
+	return &adoptedProcessImpl{
+		cmd: cmd,
+		pid: pid,
+	}
+}
+
+/*
+NewAdoptedProcessFactory creates a factory that adopts the given pid on first call. Consecutive calls (restarts)
+falls back to the given factory.
+*/
+func NewAdoptedProcessFactory(pid int, fallback ProcessFactory) ProcessFactory {
+	adopted := false
+	factory := func(table PIDTable, cmd *core.Command) Process {
+		if adopted {
+			return fallback(table, cmd)
+		}
+
+		adopted = true
+		return NewAdoptedProcess(pid, cmd)
+	}
+
+	return factory
+}
+
+func (process *adoptedProcessImpl) Command() *core.Command {
+	return process.cmd
+}
+
+func (process *adoptedProcessImpl) ps() *psutils.Process {
+	process.m.Lock()
+	defer process.m.Unlock()
+
+	return process.process
+}
+
+func (process *adoptedProcessImpl) Signal(sig syscall.Signal) error {
+	ps := process.ps()
+	if ps == nil {
+		return fmt.Errorf("process is not running")
+	}
+
+	return ps.SendSignal(sig)
+}
+
+func (process *adoptedProcessImpl) Kill() {
+	if ps := process.ps(); ps != nil {
+		ps.Kill()
+	}
+}
+
+//GetStats gets stats of the adopted process
+func (process *adoptedProcessImpl) GetStats() *ProcessStats {
+	stats := ProcessStats{}
+	stats.Cmd = process.cmd
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warningf("processUtils panic: %s", r)
+		}
+	}()
+
+	ps := process.ps()
+	if ps == nil {
+		return &stats
+	}
+
+	cpu, err := ps.Percent(0)
+	if err == nil {
+		stats.CPU = cpu
+	}
+
+	mem, err := ps.MemoryInfo()
+	if err == nil {
+		stats.RSS = mem.RSS
+		stats.VMS = mem.VMS
+		stats.Swap = mem.Swap
+	}
+
+	stats.Debug = fmt.Sprintf("%d", process.pid)
+
+	return &stats
+}
+
+func (process *adoptedProcessImpl) alive() bool {
+	err := syscall.Kill(process.pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func (process *adoptedProcessImpl) Run() (<-chan *stream.Message, error) {
+	ps, err := psutils.NewProcess(int32(process.pid))
+	if err != nil {
+		return nil, err
+	}
+
+	process.m.Lock()
+	process.process = ps
+	process.m.Unlock()
+
+	channel := make(chan *stream.Message)
+
+	go func(channel chan *stream.Message) {
+		defer close(channel)
+
+		channel <- &stream.Message{
+			Level:   stream.LevelWarning,
+			Message: fmt.Sprintf("process %d was adopted after agent restart, outputs and exit status are not available", process.pid),
+		}
+
+		for process.alive() {
+			time.Sleep(adoptedPollPeriod)
+		}
+
+		//the exit status of a process that is not our child can't be collected, so it's not known if it succeeded.
+		log.Infof("Adopted process %s exited", process.cmd)
+		channel <- stream.MessageExit(core.StateLost, nil)
+	}(channel)
+
+	return channel, nil
+}
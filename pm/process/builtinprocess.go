@@ -1,28 +1,50 @@
 package process
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/pm/stream"
+	"syscall"
 )
 
 /*
-Runable represents a runnable built in function that can be managed by the process manager.
+Runable represents a runnable built in function that can be managed by the process manager. If the function
+returns both a value and an error, the value is still used as the result data but the job fails, and the error
+is the critical message of the result.
 */
 type Runnable func(*core.Command) (interface{}, error)
 
+/*
+ContextRunnable is a Runnable that can be stopped, the context is cancelled when the job is killed. The job
+doesn't wait for the function to return once it's killed.
+*/
+type ContextRunnable func(context.Context, *core.Command) (interface{}, error)
+
 /*
 internalProcess implements a Procss interface and represents an internal (go) process that can be managed by the process manager
 */
 type internalProcess struct {
-	runnable Runnable
+	runnable ContextRunnable
 	cmd      *core.Command
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewInternalProcess(cmd *core.Command, runnable Runnable) Process {
+	return NewInternalContextProcess(cmd, func(_ context.Context, cmd *core.Command) (interface{}, error) {
+		return runnable(cmd)
+	})
+}
+
+func NewInternalContextProcess(cmd *core.Command, runnable ContextRunnable) Process {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &internalProcess{
 		runnable: runnable,
 		cmd:      cmd,
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 }
 
@@ -37,6 +59,17 @@ func NewInternalProcessFactory(runnable Runnable) ProcessFactory {
 	return factory
 }
 
+/*
+NewInternalContextProcessFactory factory to build ContextRunnable processes
+*/
+func NewInternalContextProcessFactory(runnable ContextRunnable) ProcessFactory {
+	factory := func(_ PIDTable, cmd *core.Command) Process {
+		return NewInternalContextProcess(cmd, runnable)
+	}
+
+	return factory
+}
+
 /*
 Cmd returns the internal process command
 */
@@ -53,12 +86,14 @@ func (process *internalProcess) Run() (<-chan *stream.Message, error) {
 
 	go func(channel chan *stream.Message) {
 		defer close(channel)
-		value, err := process.runnable(process.cmd)
+		defer process.cancel()
+
+		value, err := process.runnable(process.ctx, process.cmd)
 		msg := stream.Message{
 			Level: stream.LevelResultJSON,
 		}
 
-		if err != nil {
+		if err != nil && value == nil {
 			m, _ := json.Marshal(err.Error())
 			msg.Message = string(m)
 		} else {
@@ -66,6 +101,14 @@ func (process *internalProcess) Run() (<-chan *stream.Message, error) {
 			msg.Message = string(m)
 		}
 
+		if err != nil && value != nil {
+			//the value is the result data, the error must not be lost.
+			channel <- &stream.Message{
+				Level:   stream.LevelCritical,
+				Message: err.Error(),
+			}
+		}
+
 		channel <- &msg
 		if err != nil {
 			channel <- stream.MessageExitError
@@ -79,10 +122,18 @@ func (process *internalProcess) Run() (<-chan *stream.Message, error) {
 }
 
 /*
-Kill kills internal process (not implemented)
+Signal signals internal process (not implemented)
+*/
+func (process *internalProcess) Signal(sig syscall.Signal) error {
+	return NotSignalableErr
+}
+
+/*
+Kill asks the internal process to stop, only a ContextRunnable can notice it.
 */
 func (process *internalProcess) Kill() {
-	//you can't kill an internal process.
+	//you can't kill an internal process, only cancel its context.
+	process.cancel()
 }
 
 /*
@@ -0,0 +1,260 @@
+package process
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/g8os/core.base/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	//CGroupRoot is where the cgroup v2 hierarchy is mounted
+	CGroupRoot = "/sys/fs/cgroup"
+
+	//cgroupParent is the subtree that holds the cgroups of the jobs with limits
+	cgroupParent = "g8os"
+	//cpuPeriod is the cpu.max period in microseconds
+	cpuPeriod = 100000
+)
+
+//Limits are the resources limits of a job, enforced by running the job in its own cgroup (v2).
+type Limits struct {
+	//MemoryMax max memory in bytes, the job is OOM killed if it goes above it
+	MemoryMax int64 `json:"memory_max,omitempty"`
+	//CPUWeight relative cpu share of the job in [1, 10000], the default weight of a cgroup is 100
+	CPUWeight int `json:"cpu_weight,omitempty"`
+	//CPUQuota number of cpus the job can use at most (ex: 0.5 is half a cpu)
+	CPUQuota float64 `json:"cpu_quota,omitempty"`
+	//PidsMax max number of processes (and threads) of the job
+	PidsMax int `json:"pids_max,omitempty"`
+	//IOWeight relative io share of the job in [1, 10000], the default weight of a cgroup is 100
+	IOWeight int `json:"io_weight,omitempty"`
+}
+
+//Validate checks that the limits values are in range
+func (l *Limits) Validate() error {
+	if l.MemoryMax < 0 {
+		return fmt.Errorf("invalid memory_max %d", l.MemoryMax)
+	}
+	if l.CPUWeight < 0 || l.CPUWeight > 10000 {
+		return fmt.Errorf("invalid cpu_weight %d, expecting [1, 10000]", l.CPUWeight)
+	}
+	if l.CPUQuota < 0 {
+		return fmt.Errorf("invalid cpu_quota %v", l.CPUQuota)
+	}
+	if l.PidsMax < 0 {
+		return fmt.Errorf("invalid pids_max %d", l.PidsMax)
+	}
+	if l.IOWeight < 0 || l.IOWeight > 10000 {
+		return fmt.Errorf("invalid io_weight %d, expecting [1, 10000]", l.IOWeight)
+	}
+
+	return nil
+}
+
+//controllers gets the cgroup controllers needed to enforce the limits, memory is always enabled for accounting.
+func (l *Limits) controllers() []string {
+	controllers := []string{"memory"}
+	if l.CPUWeight > 0 || l.CPUQuota > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if l.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+	}
+	if l.IOWeight > 0 {
+		controllers = append(controllers, "io")
+	}
+
+	return controllers
+}
+
+//files gets the cgroup interface files values that apply the limits
+func (l *Limits) files() map[string]string {
+	files := make(map[string]string)
+	if l.MemoryMax > 0 {
+		files["memory.max"] = fmt.Sprintf("%d", l.MemoryMax)
+	}
+	if l.CPUWeight > 0 {
+		files["cpu.weight"] = fmt.Sprintf("%d", l.CPUWeight)
+	}
+	if l.CPUQuota > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", int64(l.CPUQuota*cpuPeriod), cpuPeriod)
+	}
+	if l.PidsMax > 0 {
+		files["pids.max"] = fmt.Sprintf("%d", l.PidsMax)
+	}
+	if l.IOWeight > 0 {
+		files["io.weight"] = fmt.Sprintf("default %d", l.IOWeight)
+	}
+
+	return files
+}
+
+/*
+cgroup is the cgroup of a single job, the job process and all its descendants are accounted and limited
+together. The cgroup is destroyed (and whatever is left in it is killed) when the job process exits.
+*/
+type cgroup struct {
+	path string
+
+	//the last cpu usage sample, Stats can be called by the runner meter and the stats commands at once.
+	lastUsage uint64
+	lastTime  time.Time
+	m         sync.Mutex
+}
+
+func writeCGroupFile(dir string, name string, value string) error {
+	if err := ioutil.WriteFile(path.Join(dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set cgroup %s to '%s': %s", name, value, err)
+	}
+
+	return nil
+}
+
+//enableControllers enables the controllers for the children of the given cgroup
+func enableControllers(dir string, controllers []string) error {
+	for _, controller := range controllers {
+		if err := writeCGroupFile(dir, "cgroup.subtree_control", "+"+controller); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newCGroup(id string, limits *Limits) (*cgroup, error) {
+	if !utils.Exists(path.Join(CGroupRoot, "cgroup.controllers")) {
+		return nil, fmt.Errorf("cgroup v2 is not available, can't enforce limits")
+	}
+
+	//the jobs cgroups are siblings of the agent cgroup, since a cgroup with enabled controllers can't
+	//have processes of its own.
+	parent := path.Join(CGroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+
+	controllers := limits.controllers()
+	if err := enableControllers(CGroupRoot, controllers); err != nil {
+		return nil, err
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+
+	//job ids are set by the controller, so they can't be trusted as file names.
+	dir := path.Join(parent, hex.EncodeToString([]byte(id)))
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	cg := &cgroup{path: dir}
+	for name, value := range limits.files() {
+		if err := writeCGroupFile(dir, name, value); err != nil {
+			cg.Destroy()
+			return nil, err
+		}
+	}
+
+	return cg, nil
+}
+
+func (c *cgroup) read(name string) (uint64, error) {
+	data, err := ioutil.ReadFile(path.Join(c.path, name))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+//readKey reads a value from a flat keyed file (ex: memory.events)
+func (c *cgroup) readKey(name string, key string) (uint64, error) {
+	f, err := os.Open(path.Join(c.path, name))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("%s not found in %s", key, name)
+}
+
+/*
+Open opens the cgroup directory, so the process can be cloned right into the cgroup (CLONE_INTO_CGROUP).
+This way the process never runs (or forks) outside of its limits, the caller must close the file once the
+process is started.
+*/
+func (c *cgroup) Open() (*os.File, error) {
+	return os.OpenFile(c.path, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+}
+
+//OOMKilled checks if any process of the cgroup was killed for going above the memory limit
+func (c *cgroup) OOMKilled() bool {
+	kills, err := c.readKey("memory.events", "oom_kill")
+	return err == nil && kills > 0
+}
+
+//Stats fills the stats with the cgroup accounting (which includes all the descendants of the job process)
+func (c *cgroup) Stats(stats *ProcessStats) {
+	if mem, err := c.read("memory.current"); err == nil {
+		stats.RSS = mem
+	}
+	if swap, err := c.read("memory.swap.current"); err == nil {
+		stats.Swap = swap
+	}
+
+	usage, err := c.readKey("cpu.stat", "usage_usec")
+	if err != nil {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+	if !c.lastTime.IsZero() && usage >= c.lastUsage {
+		elapsed := now.Sub(c.lastTime) / time.Microsecond
+		if elapsed > 0 {
+			stats.CPU = float64(usage-c.lastUsage) * 100 / float64(elapsed)
+		}
+	}
+
+	c.lastUsage = usage
+	c.lastTime = now
+}
+
+//Destroy kills the processes left in the cgroup and removes it
+func (c *cgroup) Destroy() {
+	//cgroup.kill is only available starting from linux 5.14
+	writeCGroupFile(c.path, "cgroup.kill", "1")
+
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(c.path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	log.Errorf("Failed to remove cgroup '%s': %s", c.path, err)
+}
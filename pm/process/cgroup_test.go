@@ -0,0 +1,68 @@
+package process
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLimitsValidate(t *testing.T) {
+	assert.NoError(t, (&Limits{MemoryMax: 1024, CPUWeight: 100, CPUQuota: 0.5, PidsMax: 10, IOWeight: 10000}).Validate())
+	assert.Error(t, (&Limits{MemoryMax: -1}).Validate())
+	assert.Error(t, (&Limits{CPUWeight: 10001}).Validate())
+	assert.Error(t, (&Limits{IOWeight: -1}).Validate())
+}
+
+func TestLimitsFiles(t *testing.T) {
+	limits := &Limits{MemoryMax: 1024, CPUQuota: 1.5, IOWeight: 50}
+
+	assert.Equal(t, map[string]string{
+		"memory.max": "1024",
+		"cpu.max":    "150000 100000",
+		"io.weight":  "default 50",
+	}, limits.files())
+	assert.Equal(t, []string{"memory", "cpu", "io"}, limits.controllers())
+}
+
+func TestCGroupAccounting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, data string) {
+		assert.NoError(t, ioutil.WriteFile(path.Join(dir, name), []byte(data), 0644))
+	}
+
+	cg := &cgroup{path: dir}
+	write("memory.events", "low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n")
+	assert.False(t, cg.OOMKilled())
+
+	write("memory.events", "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n")
+	assert.True(t, cg.OOMKilled())
+
+	write("memory.current", "4096\n")
+	write("cpu.stat", "usage_usec 1000\nuser_usec 800\nsystem_usec 200\n")
+
+	var stats ProcessStats
+	cg.Stats(&stats)
+	assert.Equal(t, uint64(4096), stats.RSS)
+	assert.Equal(t, uint64(1000), cg.lastUsage)
+}
+
+func TestExtensionInvalidLimits(t *testing.T) {
+	factory := NewExtensionProcessFactory("true", "", nil, nil)
+	ps := factory(testTable{}, &core.Command{
+		ID:        "extension",
+		Command:   "test.extension",
+		Arguments: core.MustArguments(map[string]interface{}{"limits": "unlimited"}),
+	})
+
+	//the extension must not run without its limits
+	_, err := ps.Run()
+	assert.Error(t, err)
+}
@@ -41,8 +41,17 @@ func (process *containerProcessImpl) Command() *core.Command {
 	return process.cmd
 }
 
+func (process *containerProcessImpl) Signal(sig syscall.Signal) error {
+	if process.process == nil {
+		return fmt.Errorf("process is not running")
+	}
+
+	return process.process.SendSignal(sig)
+}
+
 func (process *containerProcessImpl) Kill() {
-	//should force system process to exit.
+	//killing the init process of the pid namespace, kills all the processes
+	//of the container.
 	if process.process != nil {
 		process.process.Kill()
 	}
@@ -151,13 +160,9 @@ func (process *containerProcessImpl) Run() (<-chan *stream.Message, error) {
 		<-errConsumer.Signal()
 		state := process.table.WaitPID(process.pid)
 
-		log.Infof("Process %s exited with state: %d", process.cmd, state.ExitStatus())
+		log.Infof("Process %s exited with state: %d", process.cmd, state.Status.ExitStatus())
 
-		if state.ExitStatus() == 0 {
-			channel <- stream.MessageExitSuccess
-		} else {
-			channel <- stream.MessageExitError
-		}
+		channel <- state.Message()
 	}(channel)
 
 	return channel, nil
@@ -2,14 +2,18 @@ package process
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/pm/stream"
 	"github.com/g8os/core.base/utils"
+	"syscall"
 )
 
 type extensionProcess struct {
 	system Process
 	cmd    *core.Command
+	//err is an invalid argument, the extension is not started with arguments it can't enforce
+	err error
 }
 
 func NewExtensionProcessFactory(exe string, dir string, args []string, env map[string]string) ProcessFactory {
@@ -39,6 +43,18 @@ func NewExtensionProcessFactory(exe string, dir string, args []string, env map[s
 			delete(input, "stdin")
 		}
 
+		var argsErr error
+		if limits, ok := input["limits"]; ok {
+			sysargs.Limits = &Limits{}
+			if err := json.Unmarshal(*core.MustArguments(limits), sysargs.Limits); err != nil {
+				log.Errorf("invalid limits to extension command: %s", err)
+				sysargs.Limits = nil
+				argsErr = fmt.Errorf("invalid limits: %s", err)
+			}
+
+			delete(input, "limits")
+		}
+
 		for _, arg := range args {
 			sysargs.Args = append(sysargs.Args, utils.Format(arg, input))
 		}
@@ -53,6 +69,7 @@ func NewExtensionProcessFactory(exe string, dir string, args []string, env map[s
 		return &extensionProcess{
 			system: NewSystemProcess(table, extcmd),
 			cmd:    cmd,
+			err:    argsErr,
 		}
 	}
 
@@ -64,9 +81,17 @@ func (process *extensionProcess) Command() *core.Command {
 }
 
 func (process *extensionProcess) Run() (<-chan *stream.Message, error) {
+	if process.err != nil {
+		return nil, process.err
+	}
+
 	return process.system.Run()
 }
 
+func (process *extensionProcess) Signal(sig syscall.Signal) error {
+	return process.system.Signal(sig)
+}
+
 func (process *extensionProcess) Kill() {
 	process.system.Kill()
 }
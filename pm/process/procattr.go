@@ -0,0 +1,428 @@
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	rlimitUnlimited = -1
+
+	minNice = -20
+	maxNice = 19
+
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+	ioprioMaxLevel   = 7
+	//ioprioDefaultLevel is the level of a class given without a level
+	ioprioDefaultLevel = 4
+
+	//procAttrHelper is the name the agent is started with to run as the attributes helper
+	procAttrHelper = "g8os-procattr"
+	//helperErrFD is the helper end of the error pipe (the first of the extra files)
+	helperErrFD = 3
+)
+
+var (
+	ioprioClasses = map[string]int{
+		"realtime":    1,
+		"best-effort": 2,
+		"idle":        3,
+	}
+)
+
+//RLimits are the POSIX resource limits of a process, -1 means unlimited.
+type RLimits struct {
+	NoFile *int64 `json:"nofile,omitempty"`
+	NProc  *int64 `json:"nproc,omitempty"`
+	Core   *int64 `json:"core,omitempty"`
+}
+
+/*
+procAttr holds the validated identity and scheduling attributes of a system process. They are all set in
+the child before exec, so the process never runs without them and the agent's own attributes are left as is.
+*/
+type procAttr struct {
+	credential *syscall.Credential
+	umask      int
+	nice       int
+	ioprio     int
+	cpus       *unix.CPUSet
+	rlimits    map[int]int64
+}
+
+func lookupGroup(name string) (uint32, error) {
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		if group, err = user.LookupGroupId(name); err != nil {
+			return 0, fmt.Errorf("unknown group '%s'", name)
+		}
+	}
+
+	gid, err := strconv.ParseUint(group.Gid, 10, 32)
+	return uint32(gid), err
+}
+
+func (args *SystemCommandArguments) credential() (*syscall.Credential, error) {
+	if args.User == "" && args.Group == "" && len(args.Groups) == 0 {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid: uint32(syscall.Getuid()),
+		Gid: uint32(syscall.Getgid()),
+	}
+
+	groups := args.Groups
+	if args.User != "" {
+		u, err := user.Lookup(args.User)
+		if err != nil {
+			if u, err = user.LookupId(args.User); err != nil {
+				return nil, fmt.Errorf("unknown user '%s'", args.User)
+			}
+		}
+
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		credential.Uid = uint32(uid)
+		credential.Gid = uint32(gid)
+
+		//like a login, the process gets the supplementary groups of the user unless they are given.
+		if groups == nil {
+			if groups, err = u.GroupIds(); err != nil {
+				return nil, fmt.Errorf("failed to get groups of user '%s': %s", args.User, err)
+			}
+		}
+	}
+
+	if args.Group != "" {
+		gid, err := lookupGroup(args.Group)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid = gid
+	}
+
+	for _, name := range groups {
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, gid)
+	}
+
+	return credential, nil
+}
+
+func parseIONice(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	class, ok := ioprioClasses[parts[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid ionice class '%s'", parts[0])
+	}
+
+	level := ioprioDefaultLevel
+	if class == ioprioClasses["idle"] {
+		level = 0
+	}
+
+	if len(parts) == 2 {
+		var err error
+		if level, err = strconv.Atoi(parts[1]); err != nil || level < 0 || level > ioprioMaxLevel {
+			return 0, fmt.Errorf("invalid ionice level '%s', expecting [0, %d]", parts[1], ioprioMaxLevel)
+		}
+	}
+
+	return class<<ioprioClassShift | level, nil
+}
+
+//parseCPUs parses a cpu list of the format 0,2,4-7
+func parseCPUs(s string) (*unix.CPUSet, error) {
+	set := &unix.CPUSet{}
+	max := len(set) * 64
+
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		lower, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpus '%s'", s)
+		}
+
+		upper := lower
+		if len(bounds) == 2 {
+			if upper, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid cpus '%s'", s)
+			}
+		}
+
+		if lower < 0 || upper < lower || upper >= max {
+			return nil, fmt.Errorf("invalid cpus range '%s'", part)
+		}
+
+		for cpu := lower; cpu <= upper; cpu++ {
+			set.Set(cpu)
+		}
+	}
+
+	return set, nil
+}
+
+//procAttr validates the process attributes, so bad values fail the job before anything is spawned.
+func (args *SystemCommandArguments) procAttr() (*procAttr, error) {
+	attr := &procAttr{umask: -1}
+
+	var err error
+	if attr.credential, err = args.credential(); err != nil {
+		return nil, err
+	}
+
+	if args.Umask != "" {
+		umask, err := strconv.ParseUint(args.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			return nil, fmt.Errorf("invalid umask '%s'", args.Umask)
+		}
+		attr.umask = int(umask)
+	}
+
+	if args.Nice < minNice || args.Nice > maxNice {
+		return nil, fmt.Errorf("invalid nice %d, expecting [%d, %d]", args.Nice, minNice, maxNice)
+	}
+	attr.nice = args.Nice
+
+	if args.IONice != "" {
+		if attr.ioprio, err = parseIONice(args.IONice); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.CPUs != "" {
+		if attr.cpus, err = parseCPUs(args.CPUs); err != nil {
+			return nil, err
+		}
+	}
+
+	if limits := args.RLimits; limits != nil {
+		attr.rlimits = make(map[int]int64)
+		for resource, value := range map[int]*int64{
+			unix.RLIMIT_NOFILE: limits.NoFile,
+			unix.RLIMIT_NPROC:  limits.NProc,
+			unix.RLIMIT_CORE:   limits.Core,
+		} {
+			if value == nil {
+				continue
+			}
+			if *value < rlimitUnlimited {
+				return nil, fmt.Errorf("invalid rlimit %d", *value)
+			}
+			attr.rlimits[resource] = *value
+		}
+	}
+
+	return attr, nil
+}
+
+//helper tells if the process must be started through the attributes helper
+func (attr *procAttr) helper() bool {
+	return attr.umask >= 0 || attr.nice != 0 || attr.ioprio != 0 || attr.cpus != nil || len(attr.rlimits) != 0
+}
+
+//prepare sets the attributes that must be set before exec
+func (attr *procAttr) prepare(cmd *exec.Cmd) {
+	//the helper sets the credentials itself, after the attributes that need privileges.
+	if attr.credential == nil || attr.helper() {
+		return
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = attr.credential
+}
+
+/*
+start starts the command. If the process has attributes that can't be set with the SysProcAttr, the agent
+is started instead as the attributes helper, which sets them on itself and execs the command. start doesn't
+wait for the helper, it returns the helper error pipe that must be passed to wait.
+*/
+func (attr *procAttr) start(cmd *exec.Cmd) (*os.File, error) {
+	if !attr.helper() {
+		return nil, cmd.Start()
+	}
+
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+
+	data, err := json.Marshal(helperAttr{
+		Credential: attr.credential,
+		Umask:      attr.umask,
+		Nice:       attr.nice,
+		IOPrio:     attr.ioprio,
+		CPUs:       attr.cpus,
+		RLimits:    attr.rlimits,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.Args = append([]string{procAttrHelper, string(data), cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	cmd.ExtraFiles = []*os.File{writer}
+
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+/*
+wait waits for the helper (if any) to exec the command. The helper reports a failure to set the attributes or
+to exec on its error pipe, which is closed on a successful exec.
+*/
+func (attr *procAttr) wait(helper *os.File) error {
+	if helper == nil {
+		return nil
+	}
+	defer helper.Close()
+
+	msg, err := ioutil.ReadAll(helper)
+	if err != nil {
+		return err
+	} else if len(msg) != 0 {
+		return errors.New(string(msg))
+	}
+
+	return nil
+}
+
+//helperAttr are the attributes passed to the attributes helper
+type helperAttr struct {
+	Credential *syscall.Credential `json:"credential,omitempty"`
+	Umask      int                 `json:"umask"`
+	Nice       int                 `json:"nice,omitempty"`
+	IOPrio     int                 `json:"ioprio,omitempty"`
+	CPUs       *unix.CPUSet        `json:"cpus,omitempty"`
+	RLimits    map[int]int64       `json:"rlimits,omitempty"`
+}
+
+/*
+apply sets the attributes on the calling thread, which must be locked to its OS thread: nice, ionice and
+the cpu affinity are per thread, and exec keeps the attributes of the thread that calls it. The credentials
+go last, so dropping the privileges doesn't prevent setting the others.
+*/
+func (attr *helperAttr) apply() error {
+	for resource, value := range attr.RLimits {
+		limit := syscall.Rlimit{Cur: uint64(value), Max: uint64(value)}
+		if value == rlimitUnlimited {
+			limit = syscall.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}
+		}
+
+		//syscall.Setrlimit (unlike unix.Setrlimit) keeps the nofile limit set on exec.
+		if err := syscall.Setrlimit(resource, &limit); err != nil {
+			return fmt.Errorf("failed to set rlimit %d: %s", resource, err)
+		}
+	}
+
+	if attr.Umask >= 0 {
+		syscall.Umask(attr.Umask)
+	}
+
+	if attr.Nice != 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, attr.Nice); err != nil {
+			return fmt.Errorf("failed to set nice: %s", err)
+		}
+	}
+
+	if attr.IOPrio != 0 {
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(attr.IOPrio)); errno != 0 {
+			return fmt.Errorf("failed to set ionice: %s", errno)
+		}
+	}
+
+	if attr.CPUs != nil {
+		if err := unix.SchedSetaffinity(0, attr.CPUs); err != nil {
+			return fmt.Errorf("failed to set cpu affinity: %s", err)
+		}
+	}
+
+	if cred := attr.Credential; cred != nil {
+		groups := make([]int, 0, len(cred.Groups))
+		for _, gid := range cred.Groups {
+			groups = append(groups, int(gid))
+		}
+
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to set groups: %s", err)
+		}
+		if err := syscall.Setgid(int(cred.Gid)); err != nil {
+			return fmt.Errorf("failed to set gid: %s", err)
+		}
+		if err := syscall.Setuid(int(cred.Uid)); err != nil {
+			return fmt.Errorf("failed to set uid: %s", err)
+		}
+	}
+
+	return nil
+}
+
+//helperExec applies the attributes and execs the command, it only returns on failure
+func helperExec(data string, path string, argv []string) error {
+	var attr helperAttr
+	if err := json.Unmarshal([]byte(data), &attr); err != nil {
+		return err
+	}
+
+	if err := attr.apply(); err != nil {
+		return err
+	}
+
+	if err := syscall.Exec(path, argv, os.Environ()); err != nil {
+		return fmt.Errorf("failed to exec '%s': %s", path, err)
+	}
+
+	return nil
+}
+
+/*
+init runs the attributes helper when the agent is started as the helper, it never returns then. The agent
+packages that have inits (the builtin commands) depend on this package, so their inits never run in the helper,
+only the inits of the libraries do.
+*/
+func init() {
+	if len(os.Args) < 4 || os.Args[0] != procAttrHelper {
+		return
+	}
+
+	runtime.LockOSThread()
+	syscall.CloseOnExec(helperErrFD)
+
+	err := helperExec(os.Args[1], os.Args[2], os.Args[3:])
+	os.NewFile(helperErrFD, "error").WriteString(err.Error())
+	os.Exit(127)
+}
@@ -0,0 +1,59 @@
+package process
+
+import (
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+)
+
+func TestProcAttrDefaults(t *testing.T) {
+	args := SystemCommandArguments{Name: "ls"}
+	attr, err := args.procAttr()
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Nil(t, attr.credential)
+	assert.Equal(t, -1, attr.umask)
+	assert.Nil(t, attr.cpus)
+}
+
+func TestProcAttrValidation(t *testing.T) {
+	for _, args := range []SystemCommandArguments{
+		{User: "no-such-user-here"},
+		{Group: "no-such-group-here"},
+		{Umask: "999"},
+		{Nice: 20},
+		{IONice: "fast"},
+		{IONice: "best-effort:8"},
+		{CPUs: "a-b"},
+		{RLimits: &RLimits{NoFile: new(int64)}},
+	} {
+		if args.RLimits != nil {
+			*args.RLimits.NoFile = -2
+		}
+		_, err := args.procAttr()
+		assert.Error(t, err, "%+v", args)
+	}
+}
+
+func TestProcAttr(t *testing.T) {
+	args := SystemCommandArguments{
+		User:   "0",
+		Groups: []string{"0"},
+		Umask:  "027",
+		IONice: "idle",
+		CPUs:   "0,2-3",
+	}
+
+	attr, err := args.procAttr()
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &syscall.Credential{Uid: 0, Gid: 0, Groups: []uint32{0}}, attr.credential)
+	assert.Equal(t, 027, attr.umask)
+	assert.Equal(t, 3<<ioprioClassShift, attr.ioprio)
+	assert.Equal(t, 3, attr.cpus.Count())
+	assert.True(t, attr.cpus.IsSet(2))
+}
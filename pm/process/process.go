@@ -1,6 +1,7 @@
 package process
 
 import (
+	"errors"
 	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/pm/stream"
 	"github.com/op/go-logging"
@@ -13,15 +14,57 @@ const (
 
 var (
 	log = logging.MustGetLogger("process")
+
+	//NotSignalableErr is returned by the processes that can't be signaled, Kill can only ask them to stop.
+	NotSignalableErr = errors.New("process can't be signaled")
 )
 
 type GetPID func() (int, error)
 
+//ProcessState holds the wait status and the resource usage of an exited process
+type ProcessState struct {
+	Status syscall.WaitStatus
+	Rusage syscall.Rusage
+}
+
+func toMilliseconds(tv syscall.Timeval) int64 {
+	return int64(tv.Sec)*1000 + int64(tv.Usec)/1000
+}
+
+//Exit gets the process exit status
+func (s *ProcessState) Exit() *core.ExitStatus {
+	exit := &core.ExitStatus{
+		UserTime: toMilliseconds(s.Rusage.Utime),
+		SysTime:  toMilliseconds(s.Rusage.Stime),
+		MaxRSS:   int64(s.Rusage.Maxrss),
+	}
+
+	if s.Status.Exited() {
+		code := s.Status.ExitStatus()
+		exit.ExitCode = &code
+	} else if s.Status.Signaled() {
+		exit.Signal = SignalName(s.Status.Signal())
+		exit.CoreDump = s.Status.CoreDump()
+	}
+
+	return exit
+}
+
+//Message gets the exit state message of the process
+func (s *ProcessState) Message() *stream.Message {
+	state := core.StateError
+	if s.Status.Exited() && s.Status.ExitStatus() == 0 {
+		state = core.StateSuccess
+	}
+
+	return stream.MessageExit(state, s.Exit())
+}
+
 type PIDTable interface {
 	//Register atomic registration of PID. MUST grantee that that no wait4 will happen
 	//on any of the child process until the register operation is done.
 	Register(g GetPID) error
-	WaitPID(pid int) *syscall.WaitStatus
+	WaitPID(pid int) *ProcessState
 }
 
 //ProcessStats holds process cpu and memory usage
@@ -32,14 +75,25 @@ type ProcessStats struct {
 	VMS   uint64        `json:"vms"`
 	Swap  uint64        `json:"swap"`
 	Debug string        `json:"debug,ommitempty"`
+	//Health of the job, if it has a health check
+	Health *core.HealthStatus `json:"health,omitempty"`
 }
 
 //Process interface
 type Process interface {
 	Command() *core.Command
 	Run() (<-chan *stream.Message, error)
+	//Signal sends a signal to the process (and its children) without waiting for it to exit
+	Signal(sig syscall.Signal) error
+	//Kill forces the process and its children to exit.
 	Kill()
 	GetStats() *ProcessStats
 }
 
+//StdinWriter is implemented by the processes that can receive input while running
+type StdinWriter interface {
+	WriteStdin(data []byte) error
+	CloseStdin() error
+}
+
 type ProcessFactory func(PIDTable, *core.Command) Process
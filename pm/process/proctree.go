@@ -0,0 +1,116 @@
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const (
+	procDir = "/proc"
+)
+
+type procInfo struct {
+	pid     int
+	ppid    int
+	pgid    int
+	session int
+}
+
+//parseProcStat parses the pid, ppid, process group and session of a /proc/<pid>/stat line
+func parseProcStat(stat string) (*procInfo, error) {
+	//the command name is between parentheses and can hold spaces and parentheses itself.
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("invalid stat '%s'", stat)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(stat[:strings.Index(stat, "(")]))
+	if err != nil {
+		return nil, err
+	}
+
+	//fields after the name: state ppid pgrp session ...
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid stat '%s'", stat)
+	}
+
+	info := &procInfo{pid: pid}
+	for i, v := range []*int{&info.ppid, &info.pgid, &info.session} {
+		if *v, err = strconv.Atoi(fields[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+func readProcs() ([]*procInfo, error) {
+	infos, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+
+	procs := make([]*procInfo, 0, len(infos))
+	for _, info := range infos {
+		if _, err := strconv.Atoi(info.Name()); err != nil {
+			continue
+		}
+
+		//the process can exit while we are walking.
+		stat, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/stat", procDir, info.Name()))
+		if err != nil {
+			continue
+		}
+
+		proc, err := parseProcStat(string(stat))
+		if err != nil {
+			continue
+		}
+
+		procs = append(procs, proc)
+	}
+
+	return procs, nil
+}
+
+/*
+descendants finds the processes that belong to the tree of the root process. Those are the processes in
+the session or the process group of the root (including the daemons that were re-parented to init), the
+hinted processes, and all the children of any of them. The root itself is not part of the result.
+*/
+func descendants(procs []*procInfo, root int, hints []int) []*procInfo {
+	byPID := make(map[int]*procInfo)
+	children := make(map[int][]int)
+	queue := []int{root}
+
+	for _, proc := range procs {
+		byPID[proc.pid] = proc
+		children[proc.ppid] = append(children[proc.ppid], proc.pid)
+		if proc.session == root || proc.pgid == root {
+			queue = append(queue, proc.pid)
+		}
+	}
+
+	queue = append(queue, hints...)
+
+	found := map[int]bool{root: true}
+	result := make([]*procInfo, 0)
+	for i := 0; i < len(queue); i++ {
+		pid := queue[i]
+		if i > 0 {
+			proc, ok := byPID[pid]
+			if !ok || found[pid] {
+				continue
+			}
+			found[pid] = true
+			result = append(result, proc)
+		}
+
+		queue = append(queue, children[pid]...)
+	}
+
+	return result
+}
@@ -0,0 +1,64 @@
+package process
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	proc, err := parseProcStat("1234 (my (odd) name) S 1 1200 1100 0 -1 4194560 108 0 0 0")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &procInfo{pid: 1234, ppid: 1, pgid: 1200, session: 1100}, proc)
+
+	_, err = parseProcStat("garbage")
+	assert.Error(t, err)
+}
+
+func pids(procs []*procInfo) []int {
+	result := make([]int, 0, len(procs))
+	for _, proc := range procs {
+		result = append(result, proc.pid)
+	}
+	return result
+}
+
+func TestDescendants(t *testing.T) {
+	procs := []*procInfo{
+		{pid: 1, ppid: 0, pgid: 1, session: 1},
+		{pid: 100, ppid: 1, pgid: 100, session: 100},
+		//child and grand child
+		{pid: 101, ppid: 100, pgid: 100, session: 100},
+		{pid: 102, ppid: 101, pgid: 102, session: 100},
+		//daemon re-parented to init but still in the session
+		{pid: 103, ppid: 1, pgid: 103, session: 100},
+		//daemon that left the session, and its child
+		{pid: 104, ppid: 1, pgid: 104, session: 104},
+		{pid: 105, ppid: 104, pgid: 104, session: 104},
+		//unrelated
+		{pid: 200, ppid: 1, pgid: 200, session: 200},
+	}
+
+	assert.ElementsMatch(t, []int{101, 102, 103}, pids(descendants(procs, 100, nil)))
+	assert.ElementsMatch(t, []int{101, 102, 103, 104, 105}, pids(descendants(procs, 100, []int{104, 999})))
+}
+
+func TestReadProcs(t *testing.T) {
+	procs, err := readProcs()
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	found := false
+	for _, proc := range procs {
+		if proc.pid == os.Getpid() {
+			found = true
+			assert.Equal(t, os.Getppid(), proc.ppid)
+		}
+	}
+
+	assert.True(t, found)
+}
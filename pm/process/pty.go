@@ -0,0 +1,273 @@
+package process
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm/stream"
+	"github.com/g8os/core.base/settings"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTerminalRows = 24
+	defaultTerminalCols = 80
+
+	ptyReadSize = 4096
+	//eot is the end of transmission (ctrl-d) character
+	eot = 0x04
+)
+
+//Terminal is implemented by the processes that can run in a pseudo-terminal
+type Terminal interface {
+	//Input writes data to the terminal, as if it was typed
+	Input(data []byte) error
+	//Resize changes the terminal window size
+	Resize(rows uint16, cols uint16) error
+}
+
+//control runs fn with the file descriptor, unlike Fd it leaves the file non blocking so it supports deadlines.
+func control(f *os.File, fn func(fd int) error) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	if err := conn.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	}); err != nil {
+		return err
+	}
+
+	return fnErr
+}
+
+//openPTY allocates a pseudo-terminal, the slave side is given to the process.
+func openPTY() (master *os.File, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+
+	var n int
+	err = control(master, func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return fmt.Errorf("failed to unlock pty: %s", err)
+		}
+
+		var err error
+		if n, err = unix.IoctlGetInt(fd, unix.TIOCGPTN); err != nil {
+			return fmt.Errorf("failed to get pty number: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
+
+/*
+sessionRecorder records a terminal session in the asciicast v2 format (a json header, then one json
+[time, type, data] event per line) so it can be replayed later. A nil recorder is valid and records
+nothing, which is the case when no sessions dir is configured.
+*/
+type sessionRecorder struct {
+	file    *os.File
+	encoder *json.Encoder
+	start   time.Time
+	m       sync.Mutex
+}
+
+func newSessionRecorder(id string, rows uint16, cols uint16) *sessionRecorder {
+	dir := settings.Settings.Main.Sessions
+	if dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("Failed to create sessions dir: %s", err)
+		return nil
+	}
+
+	//job ids are set by the controller, so they can't be trusted as file names.
+	name := path.Join(dir, fmt.Sprintf("%s-%d.cast", hex.EncodeToString([]byte(id)), time.Now().Unix()))
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Errorf("Failed to record session of %s: %s", id, err)
+		return nil
+	}
+
+	recorder := &sessionRecorder{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		start:   time.Now(),
+	}
+
+	recorder.encoder.Encode(map[string]interface{}{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": recorder.start.Unix(),
+		"title":     id,
+	})
+
+	return recorder
+}
+
+//Event records an output (o), input (i) or resize (r) event
+func (r *sessionRecorder) Event(kind string, data string) {
+	if r == nil {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	elapsed := time.Since(r.start).Seconds()
+	if err := r.encoder.Encode([]interface{}{elapsed, kind, data}); err != nil {
+		log.Errorf("Failed to record session event: %s", err)
+	}
+}
+
+func (r *sessionRecorder) Close() {
+	if r == nil {
+		return
+	}
+
+	r.file.Close()
+}
+
+//terminal is the master side of the pseudo-terminal of a process
+type terminal struct {
+	master   *os.File
+	slave    *os.File
+	recorder *sessionRecorder
+	//recordInput records the input events too
+	recordInput bool
+	signal      chan int
+}
+
+func newTerminal(id string, rows uint16, cols uint16, recordInput bool) (*terminal, error) {
+	if rows == 0 {
+		rows = defaultTerminalRows
+	}
+	if cols == 0 {
+		cols = defaultTerminalCols
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &terminal{
+		master:      master,
+		slave:       slave,
+		recordInput: recordInput,
+		signal:      make(chan int),
+	}
+
+	if err := t.setSize(rows, cols); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	t.recorder = newSessionRecorder(id, rows, cols)
+	return t, nil
+}
+
+func (t *terminal) setSize(rows uint16, cols uint16) error {
+	return control(t.master, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Row: rows, Col: cols})
+	})
+}
+
+//Input writes to the terminal
+func (t *terminal) Input(data []byte) error {
+	if t.recordInput {
+		t.recorder.Event("i", string(data))
+	}
+	return writeInput(t.master, data)
+}
+
+//Resize resizes the terminal, the process gets a SIGWINCH
+func (t *terminal) Resize(rows uint16, cols uint16) error {
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+
+	if err := t.setSize(rows, cols); err != nil {
+		return err
+	}
+
+	t.recorder.Event("r", fmt.Sprintf("%dx%d", cols, rows))
+	return nil
+}
+
+/*
+Consume streams the terminal output as is, a terminal output is not line oriented (prompts, progress bars)
+so each read is sent as a stdout message. The process must be started before, since the terminal
+releases its copy of the slave side.
+*/
+func (t *terminal) Consume(handler stream.MessageHandler) {
+	t.slave.Close()
+
+	go func() {
+		defer func() {
+			t.signal <- 1
+			close(t.signal)
+		}()
+
+		buffer := make([]byte, ptyReadSize)
+		for {
+			n, err := t.master.Read(buffer)
+			if n > 0 {
+				output := string(buffer[:n])
+				t.recorder.Event("o", output)
+				handler(&stream.Message{
+					Level:   stream.LevelStdout,
+					Message: output,
+				})
+			}
+
+			if err != nil {
+				//reading the master fails with EIO once all the slave copies are closed.
+				if perr, ok := err.(*os.PathError); err != io.EOF && (!ok || perr.Err != unix.EIO) {
+					log.Errorf("Failed to read terminal: %s", err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+//Signal is closed once the terminal output is done
+func (t *terminal) Signal() <-chan int {
+	return t.signal
+}
+
+//Close releases the terminal
+func (t *terminal) Close() {
+	t.slave.Close()
+	t.master.Close()
+	t.recorder.Close()
+}
@@ -0,0 +1,118 @@
+package process
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSessionRecorderDisabled(t *testing.T) {
+	settings.Settings.Main.Sessions = ""
+
+	recorder := newSessionRecorder("job", 24, 80)
+	assert.Nil(t, recorder)
+
+	//a nil recorder records nothing
+	recorder.Event("o", "output")
+	recorder.Close()
+}
+
+func TestSessionRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(dir)
+
+	settings.Settings.Main.Sessions = dir
+	defer func() {
+		settings.Settings.Main.Sessions = ""
+	}()
+
+	recorder := newSessionRecorder("job/1", 24, 80)
+	if !assert.NotNil(t, recorder) {
+		t.Fatal()
+	}
+
+	recorder.Event("o", "$ ")
+	recorder.Event("i", "ls\n")
+	recorder.Close()
+
+	files, err := ioutil.ReadDir(dir)
+	if !assert.NoError(t, err) || !assert.Len(t, files, 1) {
+		t.Fatal()
+	}
+
+	f, err := os.Open(path.Join(dir, files[0].Name()))
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var header map[string]interface{}
+	assert.True(t, scanner.Scan())
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, float64(2), header["version"])
+	assert.Equal(t, float64(80), header["width"])
+
+	var event []interface{}
+	assert.True(t, scanner.Scan())
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+	assert.Equal(t, "o", event[1])
+	assert.Equal(t, "$ ", event[2])
+
+	assert.True(t, scanner.Scan())
+	assert.False(t, scanner.Scan())
+}
+
+func TestTerminalResize(t *testing.T) {
+	terminal, err := newTerminal("job", 0, 0, false)
+	if err != nil {
+		t.Skipf("pty not available: %s", err)
+	}
+	defer terminal.Close()
+
+	assert.Error(t, terminal.Resize(0, 80))
+	assert.NoError(t, terminal.Resize(40, 120))
+}
+
+func TestTerminalRecordInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	defer os.RemoveAll(dir)
+
+	settings.Settings.Main.Sessions = dir
+	defer func() {
+		settings.Settings.Main.Sessions = ""
+	}()
+
+	for _, record := range []bool{false, true} {
+		id := fmt.Sprintf("record-%v", record)
+		terminal, err := newTerminal(id, 0, 0, record)
+		if err != nil {
+			t.Skipf("pty not available: %s", err)
+		}
+
+		//nothing consumes the terminal output, so the echo of the input is not recorded either.
+		assert.NoError(t, terminal.Input([]byte("secret\n")))
+		terminal.Close()
+
+		data, err := ioutil.ReadFile(terminal.recorder.file.Name())
+		if !assert.NoError(t, err) {
+			t.Fatal()
+		}
+
+		//the input is only recorded on request
+		assert.Equal(t, record, strings.Contains(string(data), "secret"), id)
+	}
+}
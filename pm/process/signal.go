@@ -0,0 +1,66 @@
+package process
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	signals = map[string]syscall.Signal{
+		"SIGHUP":  syscall.SIGHUP,
+		"SIGINT":  syscall.SIGINT,
+		"SIGQUIT": syscall.SIGQUIT,
+		"SIGABRT": syscall.SIGABRT,
+		"SIGKILL": syscall.SIGKILL,
+		"SIGUSR1": syscall.SIGUSR1,
+		"SIGSEGV": syscall.SIGSEGV,
+		"SIGUSR2": syscall.SIGUSR2,
+		"SIGPIPE": syscall.SIGPIPE,
+		"SIGALRM": syscall.SIGALRM,
+		"SIGTERM": syscall.SIGTERM,
+		"SIGCONT": syscall.SIGCONT,
+		"SIGSTOP": syscall.SIGSTOP,
+		"SIGTSTP": syscall.SIGTSTP,
+	}
+)
+
+/*
+ParseSignal parses a signal given by name (SIGTERM or TERM) or by number (15). An empty
+string gives SIGTERM.
+*/
+func ParseSignal(s string) (syscall.Signal, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return syscall.SIGTERM, nil
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+
+	if sig, ok := signals[s]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("unknown signal '%s'", s)
+}
+
+//SignalName gets the name of the signal (SIGTERM), or its number if it has no known name.
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("%d", int(sig))
+}
@@ -6,31 +6,79 @@ import (
 	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/pm/stream"
 	psutils "github.com/shirou/gopsutil/process"
+	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 )
 
+//stdinWriteTimeout is how long a write to the input of a process waits for the process to read it
+var stdinWriteTimeout = 30 * time.Second
+
 type SystemCommandArguments struct {
 	Name  string            `json:"name"`
 	Dir   string            `json:"dir"`
 	Args  []string          `json:"args"`
 	Env   map[string]string `json:"env"`
 	StdIn []byte            `json:"stdin"`
+	//Limits of the process resources (optional)
+	Limits *Limits `json:"limits,omitempty"`
+
+	//User and Group to run the process as (name or id), the process gets the user supplementary
+	//groups unless Groups is set.
+	User   string   `json:"user,omitempty"`
+	Group  string   `json:"group,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	//Umask in octal (ex: 022)
+	Umask string `json:"umask,omitempty"`
+	Nice  int    `json:"nice,omitempty"`
+	//IONice is the io scheduling class (realtime, best-effort or idle) with an optional level (ex: best-effort:7)
+	IONice string `json:"ionice,omitempty"`
+	//CPUs is the cpu affinity of the process (ex: 0-3,6)
+	CPUs    string   `json:"cpus,omitempty"`
+	RLimits *RLimits `json:"rlimits,omitempty"`
+
+	//PTY runs the process in a pseudo-terminal of Rows x Cols (24x80 by default), the output is
+	//streamed as is and the input is sent with core.stdin.
+	PTY  bool   `json:"pty,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	//RecordInput records the terminal input with the session (only the output and resizes are recorded
+	//by default, the input can hold passwords).
+	RecordInput bool `json:"record_input,omitempty"`
+
+	//KeepStdinOpen keeps the process stdin open after StdIn is written, more data can be written
+	//with core.write_stdin until it is closed.
+	KeepStdinOpen bool `json:"keep_stdin_open,omitempty"`
 }
 
+/*
+systemProcessImpl is an external process started in its own session, so the process and all its descendants
+can be found (and killed) together, even the daemons that forked away from it.
+*/
 type systemProcessImpl struct {
-	cmd      *core.Command
-	args     SystemCommandArguments
-	pid      int
-	process  *psutils.Process
-	children []*psutils.Process
+	cmd     *core.Command
+	args    SystemCommandArguments
+	pid     int
+	process *psutils.Process
+	//hints are pids reported by the process itself (LevelInternalMonitorPid) that are not
+	//in its session, like processes started through another daemon.
+	hints    []int
+	children map[int]*psutils.Process
+	cgroup   *cgroup
+	terminal *terminal
+	//stdin is set while the process stdin is kept open
+	stdin *os.File
 
 	table PIDTable
+	m     sync.Mutex
 }
 
 func NewSystemProcess(table PIDTable, cmd *core.Command) Process {
 	process := &systemProcessImpl{
 		cmd:      cmd,
-		children: make([]*psutils.Process, 0),
+		children: make(map[int]*psutils.Process),
 		table:    table,
 	}
 
@@ -42,13 +90,115 @@ func (process *systemProcessImpl) Command() *core.Command {
 	return process.cmd
 }
 
+func (process *systemProcessImpl) Signal(sig syscall.Signal) error {
+	if process.process == nil {
+		return fmt.Errorf("process is not running")
+	}
+
+	//the tree is collected first, once the process is gone its children are re-parented.
+	descendants := process.descendants()
+	if err := process.process.SendSignal(sig); err != nil {
+		return err
+	}
+
+	process.signalChildren(descendants, sig)
+	return nil
+}
+
 func (process *systemProcessImpl) Kill() {
 	//should force system process to exit.
-	if process.process != nil {
-		process.process.Terminate()
+	if process.process == nil {
+		return
+	}
+
+	descendants := process.descendants()
+	process.process.Kill()
+	process.signalChildren(descendants, syscall.SIGKILL)
+}
+
+//Input writes to the process terminal
+func (process *systemProcessImpl) Input(data []byte) error {
+	if process.terminal == nil {
+		return fmt.Errorf("process is not running in a terminal")
+	}
+
+	return process.terminal.Input(data)
+}
+
+//Resize resizes the process terminal
+func (process *systemProcessImpl) Resize(rows uint16, cols uint16) error {
+	if process.terminal == nil {
+		return fmt.Errorf("process is not running in a terminal")
 	}
 
-	process.killChildren()
+	return process.terminal.Resize(rows, cols)
+}
+
+//WriteStdin writes data to the process stdin (or terminal)
+func (process *systemProcessImpl) WriteStdin(data []byte) error {
+	if process.terminal != nil {
+		return process.terminal.Input(data)
+	}
+
+	process.m.Lock()
+	stdin := process.stdin
+	process.m.Unlock()
+
+	if stdin == nil {
+		return fmt.Errorf("process stdin is not open")
+	}
+
+	return writeInput(stdin, data)
+}
+
+/*
+writeInput writes data to the input of a process, a process that doesn't read its input would block the
+write forever once the pipe (or terminal) buffer is full, so the write fails after stdinWriteTimeout.
+*/
+func writeInput(f *os.File, data []byte) error {
+	if err := f.SetWriteDeadline(time.Now().Add(stdinWriteTimeout)); err != nil {
+		return err
+	}
+	defer f.SetWriteDeadline(time.Time{})
+
+	n, err := f.Write(data)
+	if os.IsTimeout(err) {
+		return fmt.Errorf("timed out writing to the process input, %d of %d bytes were written", n, len(data))
+	}
+
+	return err
+}
+
+//CloseStdin closes the process stdin, a process in a terminal gets an end of transmission (ctrl-d) instead.
+func (process *systemProcessImpl) CloseStdin() error {
+	if process.terminal != nil {
+		return process.terminal.Input([]byte{eot})
+	}
+
+	process.m.Lock()
+	stdin := process.stdin
+	process.stdin = nil
+	process.m.Unlock()
+
+	if stdin == nil {
+		return nil
+	}
+
+	return stdin.Close()
+}
+
+//descendants gets all the processes of the process tree
+func (process *systemProcessImpl) descendants() []*procInfo {
+	procs, err := readProcs()
+	if err != nil {
+		log.Errorf("Failed to list processes: %s", err)
+	}
+
+	process.m.Lock()
+	hints := append([]int{}, process.hints...)
+	process.m.Unlock()
+
+	return descendants(procs, process.pid, hints)
 }
 
 //GetStats gets stats of an external process
@@ -81,14 +231,32 @@ func (process *systemProcessImpl) GetStats() *ProcessStats {
 
 	stats.Debug = fmt.Sprintf("%d", process.process.Pid)
 
-	for i := 0; i < len(process.children); i++ {
-		child := process.children[i]
+	if process.cgroup != nil {
+		//the cgroup accounts for all the descendants, not only the tracked children.
+		process.cgroup.Stats(&stats)
+		return &stats
+	}
+
+	descendants := process.descendants()
+
+	process.m.Lock()
+	defer process.m.Unlock()
+
+	alive := make(map[int]bool)
+	for _, proc := range descendants {
+		alive[proc.pid] = true
+
+		//the same psutil process is kept between calls, the cpu percent is computed since the last call.
+		child, ok := process.children[proc.pid]
+		if !ok {
+			if child, err = psutils.NewProcess(int32(proc.pid)); err != nil {
+				continue
+			}
+			process.children[proc.pid] = child
+		}
 
 		childCPU, err := child.Percent(0)
 		if err != nil {
-			log.Errorf("%s", err)
-			//remove the dead process.
-			process.children = append(process.children[:i], process.children[i+1:]...)
 			continue
 		}
 
@@ -99,8 +267,13 @@ func (process *systemProcessImpl) GetStats() *ProcessStats {
 			stats.RSS += childMem.RSS
 			stats.Swap += childMem.Swap
 			stats.VMS += childMem.VMS
-		} else {
-			log.Errorf("%s", err)
+		}
+	}
+
+	//forget about the dead processes.
+	for pid := range process.children {
+		if !alive[pid] {
+			delete(process.children, pid)
 		}
 	}
 
@@ -140,26 +313,58 @@ func (process *systemProcessImpl) processInternalMessage(msg *stream.Message) {
 			return
 		}
 		log.Infof("Tracking external process: %d", childPid)
-		child, err := psutils.NewProcess(int32(childPid))
-		if err != nil {
-			log.Errorf("%s", err)
-		}
-		process.children = append(process.children, child)
+
+		process.m.Lock()
+		process.hints = append(process.hints, childPid)
+		process.m.Unlock()
 	}
 }
 
-func (process *systemProcessImpl) killChildren() {
-	for _, child := range process.children {
-		//kill grand-child process.
-		log.Infof("Killing grandchild process '%d'", child.Pid)
+func (process *systemProcessImpl) signalChildren(descendants []*procInfo, sig syscall.Signal) {
+	//the process is the leader of its own process group.
+	if err := syscall.Kill(-process.pid, sig); err != nil && err != syscall.ESRCH {
+		log.Errorf("Failed to signal process group: %s", err)
+	}
 
-		err := child.Terminate()
-		if err != nil {
-			log.Errorf("Failed to kill child process: %s", err)
+	for _, child := range descendants {
+		if child.pgid == process.pid {
+			//already signaled with the group
+			continue
+		}
+
+		log.Infof("Sending %s to descendant process '%d'", SignalName(sig), child.pid)
+		if err := syscall.Kill(child.pid, sig); err != nil && err != syscall.ESRCH {
+			log.Errorf("Failed to signal child process: %s", err)
 		}
 	}
 }
 
+//startCGroup creates the cgroup of the process if it has limits
+func (process *systemProcessImpl) startCGroup() error {
+	limits := process.args.Limits
+	if limits == nil {
+		return nil
+	}
+
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	cgroup, err := newCGroup(process.cmd.ID, limits)
+	if err != nil {
+		return err
+	}
+
+	process.cgroup = cgroup
+	return nil
+}
+
+func (process *systemProcessImpl) stopCGroup() {
+	if process.cgroup != nil {
+		process.cgroup.Destroy()
+	}
+}
+
 func (process *systemProcessImpl) Run() (<-chan *stream.Message, error) {
 	cmd := exec.Command(process.args.Name,
 		process.args.Args...)
@@ -169,36 +374,117 @@ func (process *systemProcessImpl) Run() (<-chan *stream.Message, error) {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", k, v))
 	}
 
-	stdout, err := cmd.StdoutPipe()
+	//validate everything before spawning, bad attributes must fail the job cleanly.
+	attr, err := process.args.procAttr()
 	if err != nil {
+		log.Errorf("Failed to start process(%s): %s", process.cmd.ID, err)
 		return nil, err
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+	//a session of its own makes the process the leader of a new process group too.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	attr.prepare(cmd)
+
+	var stdin, stdinReader *os.File
+	var consumers []stream.Consumer
+	var release func()
+
+	if process.args.PTY {
+		terminal, err := newTerminal(process.cmd.ID, process.args.Rows, process.args.Cols, process.args.RecordInput)
+		if err != nil {
+			log.Errorf("Failed to start process(%s): %s", process.cmd.ID, err)
+			return nil, err
+		}
+
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = terminal.slave, terminal.slave, terminal.slave
+		//the terminal is the controlling terminal of the process session (set from the process stdin)
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+
+		process.terminal = terminal
+		consumers = []stream.Consumer{terminal}
+		release = terminal.Close
+	} else {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		//the pipe is made here rather than with StdinPipe, so the writes can have a deadline.
+		if stdinReader, stdin, err = os.Pipe(); err != nil {
+			return nil, err
+		}
+		cmd.Stdin = stdinReader
+
+		consumers = []stream.Consumer{
+			stream.NewConsumer(stdout, stream.LevelStdout),
+			stream.NewConsumer(stderr, stream.LevelStderr),
+		}
+		release = func() {
+			stdout.Close()
+			stderr.Close()
+			stdinReader.Close()
+			stdin.Close()
+		}
 	}
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
+	if err := process.startCGroup(); err != nil {
+		log.Errorf("Failed to start process(%s): %s", process.cmd.ID, err)
+		release()
 		return nil, err
 	}
 
+	if process.cgroup != nil {
+		dir, err := process.cgroup.Open()
+		if err != nil {
+			log.Errorf("Failed to start process(%s): %s", process.cmd.ID, err)
+			release()
+			process.stopCGroup()
+			return nil, err
+		}
+		defer dir.Close()
+
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	}
+
 	//starttime := time.Duration(time.Now().UnixNano()) / time.Millisecond // start time in msec
+	var helper *os.File
 	err = process.table.Register(func() (int, error) {
-		err := cmd.Start()
-		if err != nil {
+		var err error
+		if helper, err = attr.start(cmd); err != nil {
 			return 0, err
 		}
 
 		return cmd.Process.Pid, nil
 	})
 
+	//the helper is waited for once its pid is registered, so the table is not locked while it sets the
+	//attributes, and its exit is not missed if it fails.
+	if err == nil {
+		if err = attr.wait(helper); err != nil {
+			cmd.Process.Kill()
+			process.table.WaitPID(cmd.Process.Pid)
+		}
+	}
+
 	if err != nil {
 		log.Errorf("Failed to start process(%s): %s", process.cmd.ID, err)
+		release()
+		process.stopCGroup()
 		return nil, err
 	}
 
+	if stdinReader != nil {
+		//the process has its own copy
+		stdinReader.Close()
+	}
+
 	channel := make(chan *stream.Message)
 
 	process.pid = cmd.Process.Pid
@@ -221,38 +507,61 @@ func (process *systemProcessImpl) Run() (<-chan *stream.Message, error) {
 	}
 
 	// start consuming outputs.
-	outConsumer := stream.NewConsumer(stdout, 1)
-	outConsumer.Consume(msgInterceptor)
+	for _, consumer := range consumers {
+		consumer.Consume(msgInterceptor)
+	}
 
-	errConsumer := stream.NewConsumer(stderr, 2)
-	errConsumer.Consume(msgInterceptor)
+	if process.terminal != nil {
+		if len(process.args.StdIn) != 0 {
+			if err := process.terminal.Input(process.args.StdIn); err != nil {
+				log.Errorf("Failed to write to process terminal: %s", err)
+			}
+		}
+	} else {
+		if len(process.args.StdIn) != 0 {
+			//write data to command stdin.
+			_, err = stdin.Write(process.args.StdIn)
+			if err != nil {
+				log.Errorf("Failed to write to process stdin: %s", err)
+			}
+		}
 
-	if len(process.args.StdIn) != 0 {
-		//write data to command stdin.
-		_, err = stdin.Write(process.args.StdIn)
-		if err != nil {
-			log.Errorf("Failed to write to process stdin: %s", err)
+		if process.args.KeepStdinOpen {
+			process.m.Lock()
+			process.stdin = stdin
+			process.m.Unlock()
+		} else {
+			stdin.Close()
 		}
 	}
 
-	stdin.Close()
-
 	go func(channel chan *stream.Message) {
 		//make sure all outputs are closed before waiting for the process
 		//to exit.
 		defer close(channel)
 
-		<-outConsumer.Signal()
-		<-errConsumer.Signal()
+		for _, consumer := range consumers {
+			<-consumer.Signal()
+		}
 		state := process.table.WaitPID(process.pid)
 
-		log.Infof("Process %s exited with state: %d", process.cmd, state.ExitStatus())
-
-		if state.ExitStatus() == 0 {
-			channel <- stream.MessageExitSuccess
+		if process.terminal != nil {
+			process.terminal.Close()
 		} else {
-			channel <- stream.MessageExitError
+			process.CloseStdin()
 		}
+
+		log.Infof("Process %s exited with state: %d", process.cmd, state.Status.ExitStatus())
+
+		msg := state.Message()
+		if process.cgroup != nil {
+			if process.cgroup.OOMKilled() {
+				msg.Message = core.StateOOMKilled
+			}
+			process.stopCGroup()
+		}
+
+		channel <- msg
 	}(channel)
 
 	return channel, nil
@@ -0,0 +1,187 @@
+package process
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/stream"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//testTable waits for the processes of the test itself, there is no PM reaping the children.
+type testTable struct{}
+
+func (t testTable) Register(g GetPID) error {
+	_, err := g()
+	return err
+}
+
+func (t testTable) WaitPID(pid int) *ProcessState {
+	state := &ProcessState{}
+	syscall.Wait4(pid, &state.Status, 0, &state.Rusage)
+	return state
+}
+
+func runSystem(t *testing.T, args map[string]interface{}, fn func(Process)) *stream.Message {
+	ps := NewSystemProcess(testTable{}, &core.Command{
+		ID:        "test",
+		Command:   CommandSystem,
+		Arguments: core.MustArguments(args),
+	})
+
+	channel, err := ps.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fn != nil {
+		fn(ps)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-channel:
+			if !ok {
+				t.Fatal("no exit state")
+			}
+			if msg.Level == stream.LevelExitState {
+				return msg
+			}
+		case <-timeout:
+			ps.Kill()
+			t.Fatal("process didn't exit")
+		}
+	}
+}
+
+func TestSystemProcessExitStatus(t *testing.T) {
+	msg := runSystem(t, map[string]interface{}{
+		"name": "sh",
+		"args": []string{"-c", "exit 3"},
+	}, nil)
+
+	if !assert.Equal(t, core.StateError, msg.Message) || !assert.NotNil(t, msg.Exit) {
+		t.Fatal()
+	}
+
+	if assert.NotNil(t, msg.Exit.ExitCode) {
+		assert.Equal(t, 3, *msg.Exit.ExitCode)
+	}
+	assert.Empty(t, msg.Exit.Signal)
+}
+
+func TestSystemProcessKilledStatus(t *testing.T) {
+	msg := runSystem(t, map[string]interface{}{
+		"name": "sleep",
+		"args": []string{"30"},
+	}, func(ps Process) {
+		assert.NoError(t, ps.Signal(syscall.SIGTERM))
+	})
+
+	if !assert.Equal(t, core.StateError, msg.Message) || !assert.NotNil(t, msg.Exit) {
+		t.Fatal()
+	}
+
+	//a killed process has no exit code, only the signal that terminated it.
+	assert.Nil(t, msg.Exit.ExitCode)
+	assert.Equal(t, "SIGTERM", msg.Exit.Signal)
+}
+
+func TestSystemProcessAttributes(t *testing.T) {
+	nofile := int64(100)
+	channel := make(chan *stream.Message, 10)
+
+	ps := NewSystemProcess(testTable{}, &core.Command{
+		ID:      "attributes",
+		Command: CommandSystem,
+		Arguments: core.MustArguments(SystemCommandArguments{
+			Name:    "sh",
+			Args:    []string{"-c", `echo $(umask) $(ulimit -n) $(cut -d " " -f 19 /proc/self/stat)`},
+			Umask:   "077",
+			Nice:    5,
+			RLimits: &RLimits{NoFile: &nofile},
+		}),
+	})
+
+	output, err := ps.Run()
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	go func() {
+		for msg := range output {
+			channel <- msg
+		}
+		close(channel)
+	}()
+
+	var stdout string
+	for msg := range channel {
+		if msg.Level == stream.LevelStdout {
+			stdout = msg.Message
+		}
+	}
+
+	//nice is the 19th field of the stat of the process itself, not of the agent.
+	assert.Equal(t, "0077 100 5", stdout)
+
+	//the agent umask is left as is
+	old := syscall.Umask(022)
+	syscall.Umask(old)
+	assert.NotEqual(t, 077, old)
+}
+
+func TestSystemProcessAttributesFailure(t *testing.T) {
+	ps := NewSystemProcess(testTable{}, &core.Command{
+		ID:      "attributes",
+		Command: CommandSystem,
+		Arguments: core.MustArguments(SystemCommandArguments{
+			Name: "true",
+			CPUs: "1023",
+		}),
+	})
+
+	//the helper fails to set the affinity to a cpu that doesn't exist, and reports it.
+	_, err := ps.Run()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cpu affinity")
+	}
+}
+
+func TestSystemProcessWriteStdin(t *testing.T) {
+	msg := runSystem(t, map[string]interface{}{
+		"name":            "sh",
+		"args":            []string{"-c", `read line; test "$line" = hello`},
+		"keep_stdin_open": true,
+	}, func(ps Process) {
+		writer := ps.(StdinWriter)
+		assert.NoError(t, writer.WriteStdin([]byte("hello\n")))
+		assert.NoError(t, writer.CloseStdin())
+	})
+
+	assert.Equal(t, core.StateSuccess, msg.Message)
+}
+
+func TestSystemProcessWriteStdinTimeout(t *testing.T) {
+	timeout := stdinWriteTimeout
+	stdinWriteTimeout = 100 * time.Millisecond
+	defer func() {
+		stdinWriteTimeout = timeout
+	}()
+
+	runSystem(t, map[string]interface{}{
+		"name":            "sleep",
+		"args":            []string{"30"},
+		"keep_stdin_open": true,
+	}, func(ps Process) {
+		//the process never reads, so the write can't go past the pipe buffer.
+		err := ps.(StdinWriter).WriteStdin(make([]byte, 1024*1024))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "timed out")
+		}
+
+		ps.Kill()
+	})
+}
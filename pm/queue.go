@@ -3,18 +3,31 @@ package pm
 import (
 	"container/list"
 	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/settings"
 	"sync"
 )
 
+/**
+cmdQueue holds the commands waiting on a queue, a queue runs up to `slots` commands concurrently. The
+commands that got a slot are kept in running, so only them can release a slot.
+*/
+type cmdQueue struct {
+	cmds    *list.List
+	slots   int
+	running map[*core.Command]bool
+}
+
 /**
 cmdQueueManager is used for sequential cmds exectuions
 */
 type cmdQueueManager struct {
-	queues   map[string]*list.List
+	queues   map[string]*cmdQueue
 	signal   chan string
 	consumer chan *core.Command
 	producer chan *core.Command
 	lock     sync.Mutex
+
+	journal *journal
 }
 
 /*
@@ -22,7 +35,7 @@ NewCmdQueueManager creates a new instace of the queue manager. Normally only the
 */
 func newCmdQueueManager() *cmdQueueManager {
 	mgr := &cmdQueueManager{
-		queues:   make(map[string]*list.List),
+		queues:   make(map[string]*cmdQueue),
 		signal:   make(chan string),
 		consumer: make(chan *core.Command),
 		producer: make(chan *core.Command),
@@ -37,6 +50,22 @@ func newCmdQueueManager() *cmdQueueManager {
 	return mgr
 }
 
+/*
+queueSlots gets the number of slots of a new queue. Slots declared in the settings take precedence over the
+slots requested by the first command that uses the queue. Queues are serial by default.
+*/
+func queueSlots(cmd *core.Command) int {
+	if cfg, ok := settings.Settings.Queue[cmd.Queue]; ok && cfg.Slots > 0 {
+		return cfg.Slots
+	}
+
+	if cmd.QueueSlots > 0 {
+		return cmd.QueueSlots
+	}
+
+	return 1
+}
+
 func (mgr *cmdQueueManager) dispatcherLoop() {
 	for {
 		cmd := <-mgr.consumer
@@ -52,21 +81,21 @@ func (mgr *cmdQueueManager) dispatcherLoop() {
 		mgr.lock.Lock()
 		queue, ok := mgr.queues[cmd.Queue]
 		if !ok {
-			log.Debugf("Queue '%s' doesn't exist, initializing...", cmd.Queue)
-			queue = list.New()
+			queue = &cmdQueue{
+				cmds:    list.New(),
+				slots:   queueSlots(cmd),
+				running: make(map[*core.Command]bool),
+			}
+			log.Debugf("Queue '%s' doesn't exist, initializing with %d slot(s)...", cmd.Queue, queue.slots)
 			mgr.queues[cmd.Queue] = queue
 		}
-		mgr.lock.Unlock()
 		//push the command to the queue.
-		queue.PushBack(cmd)
+		queue.cmds.PushBack(cmd)
+		mgr.lock.Unlock()
 
-		if !ok {
-			//since we just create this queue. We signal that it's ready
-			//to produce the first queued command. Think of it as intial start
-			//condition. When this command exists, it will auto signal the next command
-			//and so on.
-			mgr.signal <- cmd.Queue
-		}
+		//signal that the queue may have a free slot for this command. Think of it as intial start
+		//condition. When a command exists, it will auto signal the next command and so on.
+		mgr.signal <- cmd.Queue
 	}
 }
 
@@ -84,35 +113,75 @@ func (mgr *cmdQueueManager) producerLoop() {
 			continue
 		}
 
-		if queue.Len() == 0 {
-			//last command on this queue exited successfully.
-			//we can safely delete it.
-			log.Infof("Cleaning up  queue '%s'", queueName)
-			delete(mgr.queues, queueName)
+		if queue.cmds.Len() == 0 {
+			if len(queue.running) == 0 {
+				//last command on this queue exited successfully.
+				//we can safely delete it.
+				log.Infof("Cleaning up  queue '%s'", queueName)
+				delete(mgr.queues, queueName)
+			}
 			mgr.lock.Unlock()
 			continue
 		}
 
+		if len(queue.running) >= queue.slots {
+			//all slots are busy, a finishing command will signal again.
+			mgr.lock.Unlock()
+			continue
+		}
+
+		next := queue.cmds.Remove(queue.cmds.Front()).(*core.Command)
+		queue.running[next] = true
 		mgr.lock.Unlock()
 
-		next := queue.Remove(queue.Front()).(*core.Command)
 		mgr.producer <- next
 	}
 }
 
 func (mgr *cmdQueueManager) Push(cmd *core.Command) {
+	mgr.journal.Queue(cmd)
 	mgr.consumer <- cmd
 }
 
+/*
+Notify releases the queue slot that was taken by cmd. A command that never got a slot (it didn't go through the
+queue, or was replayed) has nothing to release.
+*/
 func (mgr *cmdQueueManager) Notify(cmd *core.Command) {
 	if cmd.Queue == "" {
 		//nothing to do
 		return
 	}
 
+	mgr.lock.Lock()
+	queue, ok := mgr.queues[cmd.Queue]
+	if !ok || !queue.running[cmd] {
+		mgr.lock.Unlock()
+		return
+	}
+
+	delete(queue.running, cmd)
+	mgr.lock.Unlock()
+
 	mgr.signal <- cmd.Queue
 }
 
+//Drain removes all the commands waiting on the queues, the commands that are already running keep their slots.
+func (mgr *cmdQueueManager) Drain() []*core.Command {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	cmds := make([]*core.Command, 0)
+	for _, queue := range mgr.queues {
+		for e := queue.cmds.Front(); e != nil; e = e.Next() {
+			cmds = append(cmds, e.Value.(*core.Command))
+		}
+		queue.cmds.Init()
+	}
+
+	return cmds
+}
+
 func (mgr *cmdQueueManager) Producer() <-chan *core.Command {
 	return mgr.producer
 }
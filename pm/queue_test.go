@@ -0,0 +1,111 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func next(mgr *cmdQueueManager, timeout time.Duration) *core.Command {
+	select {
+	case cmd := <-mgr.Producer():
+		return cmd
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestQueue_Serial(t *testing.T) {
+	mgr := newCmdQueueManager()
+
+	go func() {
+		mgr.Push(&core.Command{ID: "1", Queue: "serial"})
+		mgr.Push(&core.Command{ID: "2", Queue: "serial"})
+	}()
+
+	first := next(mgr, time.Second)
+	if !assert.NotNil(t, first) || !assert.Equal(t, "1", first.ID) {
+		t.Fatal()
+	}
+
+	//second command must wait for the first to finish
+	if !assert.Nil(t, next(mgr, 100*time.Millisecond)) {
+		t.Fatal()
+	}
+
+	mgr.Notify(first)
+
+	second := next(mgr, time.Second)
+	if !assert.NotNil(t, second) || !assert.Equal(t, "2", second.ID) {
+		t.Fatal()
+	}
+}
+
+func TestQueue_Slots(t *testing.T) {
+	mgr := newCmdQueueManager()
+
+	//push blocks until the dispatcher is free, so it can't run on the consuming routine
+	go func() {
+		mgr.Push(&core.Command{ID: "1", Queue: "builds", QueueSlots: 2})
+		mgr.Push(&core.Command{ID: "2", Queue: "builds"})
+		mgr.Push(&core.Command{ID: "3", Queue: "builds"})
+	}()
+
+	first := next(mgr, time.Second)
+	second := next(mgr, time.Second)
+	if !assert.NotNil(t, first) || !assert.NotNil(t, second) {
+		t.Fatal()
+	}
+
+	//both slots are taken
+	if !assert.Nil(t, next(mgr, 100*time.Millisecond)) {
+		t.Fatal()
+	}
+
+	//a command that didn't get a slot from the queue can't release one.
+	mgr.Notify(&core.Command{ID: "direct", Queue: "builds"})
+	if !assert.Nil(t, next(mgr, 100*time.Millisecond)) {
+		t.Fatal()
+	}
+
+	mgr.Notify(second)
+	//releasing twice doesn't free another slot.
+	mgr.Notify(second)
+
+	third := next(mgr, time.Second)
+	if !assert.NotNil(t, third) || !assert.Equal(t, "3", third.ID) {
+		t.Fatal()
+	}
+
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !assert.Len(t, mgr.queues["builds"].running, 2) {
+		t.Fail()
+	}
+}
+
+func TestQueue_Drain(t *testing.T) {
+	mgr := newCmdQueueManager()
+
+	mgr.Push(&core.Command{ID: "1", Queue: "serial"})
+	if !assert.NotNil(t, next(mgr, time.Second)) {
+		t.Fatal()
+	}
+
+	mgr.Push(&core.Command{ID: "2", Queue: "serial"})
+	mgr.Push(&core.Command{ID: "3", Queue: "other"})
+
+	//the running command keeps its slot, so only 3 can be produced in the meantime.
+	next(mgr, 100*time.Millisecond)
+
+	drained := mgr.Drain()
+	ids := make([]string, 0)
+	for _, cmd := range drained {
+		ids = append(ids, cmd.ID)
+	}
+
+	if !assert.Contains(t, ids, "2") || !assert.Empty(t, mgr.Drain()) {
+		t.Fail()
+	}
+}
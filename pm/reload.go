@@ -0,0 +1,256 @@
+package pm
+
+import (
+	"fmt"
+	"github.com/g8os/core.base/settings"
+	"golang.org/x/sys/unix"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	//reloadDebounce is how long the include dir must be quiet before it's reloaded, editors and config
+	//management tools usually write a file in several steps.
+	reloadDebounce = 1 * time.Second
+
+	inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+)
+
+//ReloadReport is what changed after reloading the include dir
+type ReloadReport struct {
+	//Added, Changed and Removed are the services that were added, changed or removed
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+
+	//Conflicts are the services that were not applied, because another source has a service with the same name
+	Conflicts []string `json:"conflicts,omitempty"`
+
+	//Registered and Unregistered are the extensions that were (re)registered or unregistered
+	Registered   []string `json:"registered"`
+	Unregistered []string `json:"unregistered"`
+
+	//Boot is the report of the services that were (re)started
+	Boot *BootReport `json:"boot"`
+}
+
+/*
+Reload reloads the include dir, and applies the difference with the loaded settings:
+	- removed extensions are unregistered, new and changed ones are (re)registered.
+	- removed services are stopped, changed services are restarted (if they were running) and new services
+	  are started (unless disabled). The running dependents of a stopped or changed service are restarted too.
+
+Nothing is applied if any of the include files fails to load. It waits up to timeout (if not zero) for the
+(re)started services to be running.
+*/
+func (pm *PM) Reload(timeout time.Duration) (*ReloadReport, error) {
+	included, errs := settings.Settings.GetIncludedSettings()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to load the include dir: %v", errs)
+	}
+
+	tree, errs := included.GetStartupTree()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid startup services: %v", errs)
+	}
+
+	return pm.services.reload(included.Extension, tree, timeout), nil
+}
+
+func (r *ServiceRegistry) reloadExtensions(extensions map[string]settings.Extension, report *ReloadReport) {
+	for name := range r.extensions {
+		if _, ok := extensions[name]; !ok {
+			UnregisterCmd(name)
+			report.Unregistered = append(report.Unregistered, name)
+		}
+	}
+
+	for name, ext := range extensions {
+		if old, ok := r.extensions[name]; ok && reflect.DeepEqual(old, ext) {
+			continue
+		}
+
+		RegisterCmd(name, ext.Binary, ext.Cwd, ext.Args, ext.Env)
+		report.Registered = append(report.Registered, name)
+	}
+
+	sort.Strings(report.Registered)
+	sort.Strings(report.Unregistered)
+	r.extensions = extensions
+}
+
+func (r *ServiceRegistry) reload(extensions map[string]settings.Extension, tree settings.StartupTree, timeout time.Duration) *ReloadReport {
+	r.ops.Lock()
+	defer r.ops.Unlock()
+
+	report := &ReloadReport{}
+	r.reloadExtensions(extensions, report)
+	r.apply(SourceInclude, tree.Services(), timeout, false, report)
+
+	return report
+}
+
+/*
+Apply replaces the services of the source with the given services, and converges the running services to them
+like Reload does. If ensure is set, the services of the source that are not running (they crashed) are started
+again as well, except the ones that were stopped explicitly or that are stopped in a crash loop.
+*/
+func (r *ServiceRegistry) Apply(source string, services []settings.Startup, timeout time.Duration, ensure bool) *ReloadReport {
+	r.ops.Lock()
+	defer r.ops.Unlock()
+
+	report := &ReloadReport{}
+	r.apply(source, services, timeout, ensure, report)
+
+	return report
+}
+
+//apply must be called with the ops lock held
+func (r *ServiceRegistry) apply(source string, services []settings.Startup, timeout time.Duration, ensure bool, report *ReloadReport) {
+	wanted := make(map[string]settings.Startup)
+	accepted := make([]settings.Startup, 0, len(services))
+	for _, service := range services {
+		if owner, ok := r.owner(service.Key()); ok && owner != source {
+			report.Conflicts = append(report.Conflicts, service.Key())
+			continue
+		}
+
+		wanted[service.Key()] = service
+		accepted = append(accepted, service)
+	}
+
+	r.m.RLock()
+	old := make(map[string]settings.Startup)
+	for _, service := range r.sources[source] {
+		old[service.Key()] = service
+	}
+	r.m.RUnlock()
+
+	//restart are the services to start again, because they were stopped on the way.
+	restart := make(map[string]bool)
+	stop := func(name string) {
+		for _, stopped := range r.stop(name) {
+			restart[stopped] = true
+		}
+	}
+
+	for name, service := range old {
+		if newService, ok := wanted[name]; !ok {
+			report.Removed = append(report.Removed, name)
+			stop(name)
+		} else if !reflect.DeepEqual(service, newService) {
+			report.Changed = append(report.Changed, name)
+			stop(name)
+		}
+	}
+
+	added := make(map[string]bool)
+	for name := range wanted {
+		if _, ok := old[name]; !ok {
+			report.Added = append(report.Added, name)
+			added[name] = true
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Changed)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Conflicts)
+
+	r.setServices(source, accepted)
+
+	slice := make(settings.StartupSlice, 0)
+	for _, service := range accepted {
+		name := service.Key()
+		if added[name] || restart[name] || (ensure && !r.running(name) && r.ensurable(name)) {
+			slice = append(slice, service)
+		}
+	}
+
+	//the dependents that were stopped on the way can belong to other sources.
+	r.m.RLock()
+	for _, service := range r.services {
+		if _, ok := wanted[service.Key()]; !ok && restart[service.Key()] {
+			slice = append(slice, service)
+		}
+	}
+	r.m.RUnlock()
+
+	if len(slice) > 0 {
+		r.setHeld(false, keys(slice)...)
+
+		//only the new (or ensured) services are subject to the enabled state, a disabled service that was
+		//started by hand is still started again.
+		report.Boot = r.pm.runSlice(slice, timeout, func(name string) bool {
+			return !restart[name] && r.Disabled(name)
+		}, true)
+	}
+
+	log.Infof("Applied %s services, added: %v, changed: %v, removed: %v", source, report.Added, report.Changed, report.Removed)
+}
+
+/*
+WatchInclude reloads the include dir each time a file in it is created, changed or removed. The changes are
+debounced, so a burst of writes results in a single reload.
+*/
+func (pm *PM) WatchInclude(dir string, timeout time.Duration) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+
+	if _, err := unix.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("failed to watch '%s': %s", dir, err)
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer unix.Close(fd)
+		buffer := make([]byte, unix.SizeofInotifyEvent*64+unix.PathMax)
+		for {
+			//only the fact that something changed matters, the events themselves are not parsed.
+			if _, err := unix.Read(fd, buffer); err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+				log.Errorf("Failed to watch '%s': %s", dir, err)
+				close(events)
+				return
+			}
+
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	go func() {
+		for range events {
+			//wait until there are no more events for the debounce period.
+			for quiet := false; !quiet; {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						return
+					}
+				case <-time.After(reloadDebounce):
+					quiet = true
+				}
+			}
+
+			if pm.ShuttingDown() {
+				return
+			}
+
+			log.Infof("Include dir '%s' changed, reloading", dir)
+			if _, err := pm.Reload(timeout); err != nil {
+				log.Errorf("Failed to reload '%s': %s", dir, err)
+			}
+		}
+	}()
+
+	return nil
+}
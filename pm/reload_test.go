@@ -0,0 +1,176 @@
+package pm
+
+import (
+	"context"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const testReloaded = `
+[extension."test.ext2"]
+binary = "true"
+
+[startup.db]
+name = "core.system"
+[startup.db.args]
+name = "true"
+
+[startup.cache]
+name = "core.system"
+
+[startup.mq]
+name = "test.unknown"
+`
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	include := path.Join(dir, "include")
+	os.Mkdir(include, 0755)
+	ioutil.WriteFile(path.Join(include, "services.toml"), []byte(testServices+"\n[extension.\"test.ext1\"]\nbinary = \"true\"\n"), 0644)
+
+	old := settings.Settings
+	defer func() {
+		settings.Settings = old
+	}()
+	settings.Settings.Main.Include = include
+
+	included, errs := settings.Settings.GetIncludedSettings()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	mgr := InitProcessManager(1)
+	if err := mgr.SetIncluded(included, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.NotNil(t, GetProcessFactory(&core.Command{Command: "test.ext1"})) {
+		t.Fatal()
+	}
+
+	ioutil.WriteFile(path.Join(include, "services.toml"), []byte(testReloaded), 0644)
+
+	report, err := mgr.Reload(time.Second)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	if !assert.Equal(t, []string{"mq"}, report.Added) ||
+		!assert.Equal(t, []string{"db"}, report.Changed) ||
+		!assert.Equal(t, []string{"app", "web"}, report.Removed) ||
+		!assert.Equal(t, []string{"test.ext2"}, report.Registered) ||
+		!assert.Equal(t, []string{"test.ext1"}, report.Unregistered) {
+		t.Fail()
+	}
+
+	//the new service command is unknown, so it fails to start.
+	if !assert.NotNil(t, report.Boot) || !assert.Equal(t, 1, report.Boot.Count(BootFailed)) {
+		t.Fail()
+	}
+
+	if !assert.Nil(t, GetProcessFactory(&core.Command{Command: "test.ext1"})) ||
+		!assert.NotNil(t, GetProcessFactory(&core.Command{Command: "test.ext2"})) {
+		t.Fail()
+	}
+
+	if _, err := mgr.Services().Status("app"); !assert.Error(t, err) {
+		t.Fail()
+	}
+}
+
+func TestApply_Conflicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mgr := InitProcessManager(1)
+	if err := mgr.SetIncluded(testIncluded(t, dir), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	services := []settings.Startup{
+		settings.Startup{Name: "test.unknown"}.WithKey("db"),
+		settings.Startup{Name: "test.unknown"}.WithKey("remote"),
+	}
+
+	report := mgr.Services().Apply("desired", services, time.Second, false)
+	if !assert.Equal(t, []string{"db"}, report.Conflicts) || !assert.Equal(t, []string{"remote"}, report.Added) {
+		t.Fail()
+	}
+
+	//the include dir still owns db
+	if owner, _ := mgr.Services().owner("db"); !assert.Equal(t, SourceInclude, owner) {
+		t.Fail()
+	}
+
+	report = mgr.Services().Apply("desired", nil, time.Second, false)
+	if !assert.Equal(t, []string{"remote"}, report.Removed) {
+		t.Fail()
+	}
+}
+
+func TestApply_Ensure(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.service"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.service")
+
+	mgr := InitProcessManager(10)
+
+	services := []settings.Startup{
+		settings.Startup{Name: "test.service", RunningDelay: 1}.WithKey("crashed"),
+		settings.Startup{Name: "test.service", RunningDelay: 1}.WithKey("looping"),
+		settings.Startup{Name: "test.service", RunningDelay: 1}.WithKey("stopped"),
+	}
+	defer mgr.stopServices(context.Background(), services)
+
+	if report := mgr.Services().Apply("desired", services, 5*time.Second, true); !assert.True(t, report.Boot.OK()) {
+		t.Fatal()
+	}
+
+	for _, name := range []string{"crashed", "looping"} {
+		if runner, ok := mgr.Runner(name); ok {
+			runner.Kill()
+			runner.Wait()
+		}
+	}
+
+	if _, err := mgr.Services().Stop("stopped"); err != nil {
+		t.Fatal(err)
+	}
+
+	//the last result of looping is a crash loop
+	mgr.history.Add(&core.Command{ID: "looping", Command: "test.service"}, &core.JobResult{ID: "looping", State: core.StateCrashLoop})
+
+	mgr.Services().Apply("desired", services, 5*time.Second, true)
+
+	running := make(map[string]bool)
+	for _, service := range services {
+		_, running[service.Key()] = mgr.Runner(service.Key())
+	}
+
+	if !assert.Equal(t, map[string]bool{"crashed": true, "looping": false, "stopped": false}, running) {
+		t.Fail()
+	}
+
+	if status, _ := mgr.Services().Status("stopped"); !assert.True(t, status.Held) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,167 @@
+package pm
+
+import (
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/utils"
+	"math"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultRestartDelay      = 1 * time.Second
+	defaultRestartMultiplier = 2
+	defaultRestartMaxDelay   = 5 * time.Minute
+
+	defaultCrashLoopCount  = 5
+	defaultCrashLoopWindow = 1 * time.Minute
+)
+
+/*
+restartTracker computes the restart delays of a command and detects crash loops according to the command
+restart policy. Commands with no restart policy are restarted after a fixed delay.
+*/
+type restartTracker struct {
+	policy   *core.RestartPolicy
+	restarts []time.Time
+
+	patterns []*regexp.Regexp
+	matched  bool
+}
+
+func newRestartTracker(policy *core.RestartPolicy) (*restartTracker, error) {
+	tracker := &restartTracker{
+		policy: policy,
+	}
+
+	if policy == nil {
+		return tracker, nil
+	}
+
+	//a jitter above 1 could make the delay negative
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return nil, fmt.Errorf("invalid restart jitter %g, expecting [0, 1]", policy.Jitter)
+	}
+
+	for _, pattern := range policy.OnOutput {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid restart output pattern '%s': %s", pattern, err)
+		}
+		tracker.patterns = append(tracker.patterns, re)
+	}
+
+	return tracker, nil
+}
+
+//begin must be called before each run to clear the output matches of the previous run.
+func (t *restartTracker) begin() {
+	t.matched = false
+}
+
+//match checks an output line of the running process against the restart patterns
+func (t *restartTracker) match(line string) {
+	if t.matched {
+		return
+	}
+
+	for _, re := range t.patterns {
+		if re.MatchString(line) {
+			t.matched = true
+			return
+		}
+	}
+}
+
+//accept checks if the failed run satisfies the restart conditions
+func (t *restartTracker) accept(result *core.JobResult) bool {
+	if t.policy == nil || (len(t.policy.OnExitCodes) == 0 && len(t.patterns) == 0) {
+		//no conditions, restart on any failure.
+		return true
+	}
+
+	if result.ExitCode != nil && utils.In(t.policy.OnExitCodes, *result.ExitCode) {
+		return true
+	}
+
+	return t.matched
+}
+
+//unlimited checks if the command must be restarted regardless of its max restart.
+func (t *restartTracker) unlimited() bool {
+	return t.policy != nil && t.policy.Unlimited
+}
+
+//healthy checks if a run that lasted for d must reset the backoff.
+func (t *restartTracker) healthy(d time.Duration) bool {
+	return t.policy != nil && t.policy.ResetAfter > 0 && d >= time.Duration(t.policy.ResetAfter)*time.Second
+}
+
+//reset forgets about the previous restarts
+func (t *restartTracker) reset() {
+	t.restarts = nil
+}
+
+//delay gets the delay before the given restart attempt (starting from 1)
+func (t *restartTracker) delay(attempt int) time.Duration {
+	if t.policy == nil {
+		return defaultRestartDelay
+	}
+
+	delay := defaultRestartDelay
+	if t.policy.Delay > 0 {
+		delay = time.Duration(t.policy.Delay) * time.Second
+	}
+
+	multiplier := float64(defaultRestartMultiplier)
+	if t.policy.Multiplier > 0 {
+		multiplier = t.policy.Multiplier
+	}
+
+	max := defaultRestartMaxDelay
+	if t.policy.MaxDelay > 0 {
+		max = time.Duration(t.policy.MaxDelay) * time.Second
+	}
+
+	d := float64(delay) * math.Pow(multiplier, float64(attempt-1))
+	if d > float64(max) {
+		d = float64(max)
+	}
+
+	if t.policy.Jitter > 0 {
+		//spread the delay randomly over [d - jitter*d, d + jitter*d]
+		d += d * t.policy.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+/*
+crashLoop records a restart at the given time, and checks if the command is restarting too often. Without a
+restart policy, only the unhealthy restarts are checked (with the default limits) since they are not bounded
+by the max restart.
+*/
+func (t *restartTracker) crashLoop(now time.Time, unhealthy bool) bool {
+	if t.policy == nil && !unhealthy || t.policy != nil && t.policy.CrashLoopCount < 0 {
+		return false
+	}
+
+	count := defaultCrashLoopCount
+	window := defaultCrashLoopWindow
+	if t.policy != nil && t.policy.CrashLoopCount > 0 {
+		count = t.policy.CrashLoopCount
+	}
+	if t.policy != nil && t.policy.CrashLoopWindow > 0 {
+		window = time.Duration(t.policy.CrashLoopWindow) * time.Second
+	}
+
+	restarts := append(t.restarts, now)
+	for len(restarts) > 0 && now.Sub(restarts[0]) > window {
+		restarts = restarts[1:]
+	}
+	t.restarts = restarts
+
+	return len(restarts) > count
+}
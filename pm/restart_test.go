@@ -0,0 +1,162 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRestart_NoPolicy(t *testing.T) {
+	tracker, _ := newRestartTracker(nil)
+
+	if !assert.Equal(t, time.Second, tracker.delay(1)) || !assert.Equal(t, time.Second, tracker.delay(10)) {
+		t.Fail()
+	}
+
+	if !assert.False(t, tracker.crashLoop(time.Now(), false)) {
+		t.Fail()
+	}
+}
+
+func TestRestart_UnhealthyCrashLoop(t *testing.T) {
+	tracker, _ := newRestartTracker(nil)
+
+	//unhealthy restarts are limited even without a policy
+	now := time.Now()
+	for i := 0; i < defaultCrashLoopCount; i++ {
+		if !assert.False(t, tracker.crashLoop(now, true)) {
+			t.Fatal()
+		}
+	}
+
+	if !assert.True(t, tracker.crashLoop(now, true)) {
+		t.Fail()
+	}
+
+	//unless the policy disables the crash loop detection
+	tracker, _ = newRestartTracker(&core.RestartPolicy{CrashLoopCount: -1})
+	for i := 0; i <= defaultCrashLoopCount; i++ {
+		if !assert.False(t, tracker.crashLoop(now, true)) {
+			t.Fatal()
+		}
+	}
+}
+
+func TestRestart_Backoff(t *testing.T) {
+	tracker, _ := newRestartTracker(&core.RestartPolicy{
+		Delay:      2,
+		Multiplier: 3,
+		MaxDelay:   60,
+	})
+
+	expected := []time.Duration{2 * time.Second, 6 * time.Second, 18 * time.Second, 54 * time.Second, 60 * time.Second}
+	for i, e := range expected {
+		if !assert.Equal(t, e, tracker.delay(i+1)) {
+			t.Fail()
+		}
+	}
+}
+
+func TestRestart_Jitter(t *testing.T) {
+	tracker, _ := newRestartTracker(&core.RestartPolicy{
+		Delay:  10,
+		Jitter: 0.5,
+	})
+
+	for i := 0; i < 100; i++ {
+		d := tracker.delay(1)
+		if !assert.True(t, d >= 5*time.Second && d <= 15*time.Second, "%s out of jitter range", d) {
+			t.Fatal()
+		}
+	}
+}
+
+func TestRestart_InvalidJitter(t *testing.T) {
+	for _, jitter := range []float64{-0.5, 1.5} {
+		if _, err := newRestartTracker(&core.RestartPolicy{Jitter: jitter}); !assert.Error(t, err, "jitter %g", jitter) {
+			t.Fail()
+		}
+	}
+
+	//the whole range is valid, the delay is never negative
+	tracker, err := newRestartTracker(&core.RestartPolicy{Delay: 10, Jitter: 1})
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := tracker.delay(1); !assert.True(t, d >= 0 && d <= 20*time.Second, "%s out of jitter range", d) {
+			t.Fatal()
+		}
+	}
+}
+
+func TestRestart_CrashLoop(t *testing.T) {
+	tracker, _ := newRestartTracker(&core.RestartPolicy{
+		CrashLoopCount:  3,
+		CrashLoopWindow: 10,
+	})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !assert.False(t, tracker.crashLoop(now.Add(time.Duration(i)*time.Second), false)) {
+			t.Fatal()
+		}
+	}
+
+	//a restart out of the window doesn't count the old ones
+	if !assert.False(t, tracker.crashLoop(now.Add(20*time.Second), false)) {
+		t.Fatal()
+	}
+
+	for i := 0; i < 3; i++ {
+		tracker.crashLoop(now.Add(21*time.Second), false)
+	}
+
+	if !assert.True(t, tracker.crashLoop(now.Add(22*time.Second), false)) {
+		t.Fatal()
+	}
+}
+
+func TestRestart_Conditions(t *testing.T) {
+	tracker, err := newRestartTracker(&core.RestartPolicy{
+		OnExitCodes: []int{75},
+		OnOutput:    []string{"(?i)out of memory"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &core.JobResult{}
+	code := 1
+	result.ExitCode = &code
+
+	tracker.begin()
+	tracker.match("some error")
+	if !assert.False(t, tracker.accept(result)) {
+		t.Fail()
+	}
+
+	tracker.match("fatal: Out Of Memory")
+	if !assert.True(t, tracker.accept(result)) {
+		t.Fail()
+	}
+
+	tracker.begin()
+	code = 75
+	result.ExitCode = &code
+	if !assert.True(t, tracker.accept(result)) {
+		t.Fail()
+	}
+}
+
+func TestRestart_InvalidPattern(t *testing.T) {
+	_, err := newRestartTracker(&core.RestartPolicy{
+		OnOutput: []string{"("},
+	})
+
+	if !assert.Error(t, err) {
+		t.Fail()
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/g8os/core.base/pm/stream"
 	"github.com/g8os/core.base/stats"
 	"github.com/g8os/core.base/utils"
+	psutil "github.com/shirou/gopsutil/process"
 	"strings"
 	"sync"
 	"syscall"
@@ -16,25 +17,46 @@ import (
 const (
 	StreamBufferSize = 1000
 
+	//DefaultKillGrace the time to wait for a process to exit after the kill signal, before
+	//it gets SIGKILLed
+	DefaultKillGrace = 5 * time.Second
+
 	meterPeriod = 30 * time.Second
 )
 
 type Runner interface {
 	Command() *core.Command
 	Run()
+	//Kill terminates the process with the command kill signal and grace period
 	Kill()
+	//Terminate sends sig to the process, and escalates to SIGKILL if it didn't exit within grace.
+	//zero values fall back to the command kill signal and grace period.
+	Terminate(sig syscall.Signal, grace time.Duration)
 	Process() process.Process
+	//Health gets the health of the job, nil if the command has no health check
+	Health() *core.HealthStatus
 	Wait() *core.JobResult
 }
 
+type killRequest struct {
+	signal syscall.Signal
+	grace  time.Duration
+}
+
 type runnerImpl struct {
 	manager *PM
 	command *core.Command
 	factory process.ProcessFactory
-	kill    chan int
+	kill    chan *killRequest
+	//done is closed once the runner doesn't take kill requests anymore
+	done chan struct{}
 
-	process process.Process
-	statsd  *stats.Statsd
+	//process is read by the builtins (stdin, stats) while the runner replaces it on restarts.
+	process  process.Process
+	m        sync.Mutex
+	statsd   *stats.Statsd
+	restarts *restartTracker
+	health   *healthChecker
 
 	hooks []RunnerHook
 
@@ -71,7 +93,8 @@ func NewRunner(manager *PM, command *core.Command, factory process.ProcessFactor
 		manager: manager,
 		command: command,
 		factory: factory,
-		kill:    make(chan int),
+		kill:    make(chan *killRequest),
+		done:    make(chan struct{}),
 		hooks:   hooks,
 
 		statsd: stats.NewStatsd(
@@ -96,8 +119,54 @@ func (runner *runnerImpl) timeout() <-chan time.Time {
 	return timeout
 }
 
+/*
+terminate sends the requested signal to the process and returns the name of the sent signal, and a channel that
+fires when the grace period is over. A nil channel is returned if the process was SIGKILLed right away.
+
+A process that can't be signaled (an internal process) is only asked to stop, and terminate returns true: the
+runner doesn't wait for it to exit.
+*/
+func (runner *runnerImpl) terminate(ps process.Process, req *killRequest) (string, <-chan time.Time, bool) {
+	//signal 0 only checks that the process can be signaled.
+	if err := ps.Signal(0); err == process.NotSignalableErr {
+		ps.Kill()
+		return "", nil, true
+	}
+
+	name := process.SignalName(req.signal)
+	if req.signal != syscall.SIGKILL {
+		err := ps.Signal(req.signal)
+		if err == nil {
+			return name, time.After(req.grace), false
+		}
+
+		log.Errorf("Failed to send %s to %s, killing: %s", name, runner.command, err)
+	}
+
+	ps.Kill()
+	return process.SignalName(syscall.SIGKILL), nil, false
+}
+
+func (runner *runnerImpl) killRequest() *killRequest {
+	sig, err := process.ParseSignal(runner.command.KillSignal)
+	if err != nil {
+		log.Errorf("Invalid kill signal of %s, using SIGTERM: %s", runner.command, err)
+		sig = syscall.SIGTERM
+	}
+
+	grace := DefaultKillGrace
+	if runner.command.KillGrace > 0 {
+		grace = time.Duration(runner.command.KillGrace) * time.Second
+	}
+
+	return &killRequest{
+		signal: sig,
+		grace:  grace,
+	}
+}
+
 func (runner *runnerImpl) meter() {
-	process := runner.process
+	process := runner.Process()
 	if process == nil {
 		return
 	}
@@ -112,9 +181,8 @@ func (runner *runnerImpl) meter() {
 }
 
 func (runner *runnerImpl) run() *core.JobResult {
-	runner.process = runner.factory(runner, runner.command)
-
-	process := runner.process
+	process := runner.factory(runner, runner.command)
+	runner.setProcess(process)
 
 	starttime := time.Now()
 
@@ -140,6 +208,13 @@ func (runner *runnerImpl) run() *core.JobResult {
 	var result *stream.Message
 	var critical string
 
+	//killState is set once the process is being terminated (killed or timedout)
+	var killState string
+	var killSignal string
+	var killGrace <-chan time.Time
+	//abandoned is set if the process can't be stopped, the runner doesn't wait for its exit then.
+	var abandoned bool
+
 	stdoutBuffer := stream.NewBuffer(StreamBufferSize)
 	stderrBuffer := stream.NewBuffer(StreamBufferSize)
 
@@ -149,19 +224,52 @@ func (runner *runnerImpl) run() *core.JobResult {
 
 	handlersTicker := time.NewTicker(1 * time.Second)
 	defer handlersTicker.Stop()
+
+	var healthTick <-chan time.Time
+	var healthResult chan error
+	probing := false
+	if health := runner.health; health != nil {
+		health.begin()
+		healthTicker := time.NewTicker(health.interval)
+		defer healthTicker.Stop()
+		healthTick = healthTicker.C
+		healthResult = make(chan error, 1)
+	}
 loop:
 	for {
 		select {
-		case <-runner.kill:
-			process.Kill()
-			jobresult.State = core.StateKilled
-			break loop
+		case req := <-runner.kill:
+			if killState != "" {
+				//already terminating, a second kill doesn't wait for the grace period.
+				req.signal = syscall.SIGKILL
+			} else {
+				killState = core.StateKilled
+			}
+			killSignal, killGrace, abandoned = runner.terminate(process, req)
 		case <-timeout:
+			killState = core.StateTimeout
+			killSignal, killGrace, abandoned = runner.terminate(process, runner.killRequest())
+		case <-killGrace:
+			log.Warningf("%s didn't exit after %s, killing", runner.command, killSignal)
 			process.Kill()
-			jobresult.State = core.StateTimeout
-			break loop
+			killSignal, killGrace = "SIGKILL", nil
 		case <-meterTicker.C:
 			runner.meter()
+		case <-healthTick:
+			//probes can take time, so they run aside, one at a time.
+			if !probing && killState == "" && time.Since(starttime) >= runner.health.delay {
+				probing = true
+				go func() {
+					healthResult <- runner.health.probe()
+				}()
+			}
+		case err := <-healthResult:
+			probing = false
+			if runner.health.report(err) && killState == "" {
+				log.Errorf("%s is unhealthy (%s), restarting", runner.command, err)
+				killState = core.StateUnhealthy
+				killSignal, killGrace, abandoned = runner.terminate(process, runner.killRequest())
+			}
 		case <-handlersTicker.C:
 			d := time.Now().Sub(starttime)
 			for _, hook := range runner.hooks {
@@ -172,11 +280,24 @@ loop:
 				result = message
 			} else if message.Level == stream.LevelExitState {
 				jobresult.State = message.Message
+				if message.Exit != nil {
+					jobresult.ExitStatus = *message.Exit
+				}
+				if killState != "" {
+					jobresult.State = killState
+					if jobresult.Signal == "" {
+						jobresult.Signal = killSignal
+					}
+				}
 				break loop
 			} else if message.Level == stream.LevelStdout {
 				stdoutBuffer.Append(message.Message)
+				runner.restarts.match(message.Message)
+				runner.observe(message.Message)
 			} else if message.Level == stream.LevelStderr {
 				stderrBuffer.Append(message.Message)
+				runner.restarts.match(message.Message)
+				runner.observe(message.Message)
 			} else if message.Level == stream.LevelStatsd {
 				runner.statsd.Feed(strings.Trim(message.Message, " "))
 			} else if message.Level == stream.LevelCritical {
@@ -190,13 +311,27 @@ loop:
 			//by default, all messages are forwarded to the manager for further processing.
 			runner.manager.msgCallback(runner.command, message)
 		}
+
+		if abandoned {
+			log.Warningf("%s can't be stopped, not waiting for it to exit", runner.command)
+			jobresult.State = killState
+			break loop
+		}
 	}
 
-	runner.process = nil
+	runner.setProcess(nil)
 
-	//consume channel to the end to allow process to cleanup probabry
-	for _ = range channel {
-		//noop.
+	if abandoned {
+		//the process is left to finish on its own, its outputs are discarded.
+		go func() {
+			for _ = range channel {
+			}
+		}()
+	} else {
+		//consume channel to the end to allow process to cleanup probabry
+		for _ = range channel {
+			//noop.
+		}
 	}
 
 	if result != nil {
@@ -218,23 +353,69 @@ func (runner *runnerImpl) Run() {
 	runs := 0
 	var result *core.JobResult
 	defer func() {
+		//closed before the clean up, so a kill sent under the runners lock can't block it.
+		close(runner.done)
 		runner.statsd.Stop()
 		if result != nil {
 			runner.result = result
 			runner.manager.resultCallback(runner.command, result)
+		}
+
+		//the runner is removed before the waiters are released, so the job ID can be reused right away.
+		runner.manager.cleanUp(runner)
 
+		if result != nil {
 			runner.waitOnce.Do(func() {
 				runner.wg.Done()
 			})
 		}
-
-		runner.manager.cleanUp(runner)
 	}()
 
 	//start statsd
 	runner.statsd.Run()
-loop:
+
+	restarts, err := newRestartTracker(runner.command.Restart)
+	if err != nil {
+		result = core.NewBasicJobResult(runner.command)
+		result.State = core.StateError
+		result.Data = err.Error()
+		return
+	}
+	runner.restarts = restarts
+
+	if runner.health, err = newHealthChecker(runner.command.Health, runner.manager); err != nil {
+		result = core.NewBasicJobResult(runner.command)
+		result.State = core.StateError
+		result.Data = err.Error()
+		return
+	}
+
+	var schedule CronSchedule
+	var fire time.Time
+	if runner.command.Cron != "" {
+		if schedule, err = ParseCron(runner.command.Cron, runner.command.CronTimezone); err == nil {
+			if fire = schedule.Next(time.Now()); fire.IsZero() {
+				err = fmt.Errorf("cron expression '%s' never fires", runner.command.Cron)
+			}
+		}
+
+		if err != nil {
+			result = core.NewBasicJobResult(runner.command)
+			result.State = core.StateError
+			result.Data = err.Error()
+			return
+		}
+
+		log.Infof("Scheduling '%s' at %s", runner.command, fire)
+		if !runner.sleep(fire.Sub(time.Now())) {
+			result = core.NewBasicJobResult(runner.command)
+			result.State = core.StateKilled
+			return
+		}
+	}
+
 	for {
+		restarts.begin()
 		result = runner.run()
 
 		for _, hook := range runner.hooks {
@@ -249,12 +430,30 @@ loop:
 		restarting := false
 		var restartIn time.Duration
 
-		if result.State != core.StateSuccess && runner.command.MaxRestart > 0 {
+		//an unhealthy job is always restarted (unless it is crash looping), that's the point of the health check.
+		unhealthy := result.State == core.StateUnhealthy
+		if unhealthy || result.State != core.StateSuccess && (runner.command.MaxRestart > 0 || restarts.unlimited()) && restarts.accept(result) {
+			if restarts.healthy(time.Duration(result.Time) * time.Millisecond) {
+				//the process was running fine for long enough before it failed.
+				runs = 0
+				restarts.reset()
+			}
+
 			runs++
-			if runs < runner.command.MaxRestart {
-				log.Infof("Restarting '%s' due to upnormal exit status, trials: %d/%d", runner.command, runs+1, runner.command.MaxRestart)
+			if unhealthy || restarts.unlimited() || runs < runner.command.MaxRestart {
+				if restarts.crashLoop(time.Now(), unhealthy) {
+					log.Errorf("'%s' is restarting too often, giving up", runner.command)
+					result.State = core.StateCrashLoop
+					break
+				}
+
 				restarting = true
-				restartIn = 1 * time.Second
+				restartIn = restarts.delay(runs)
+				if unhealthy {
+					log.Infof("Restarting unhealthy '%s' in %s", runner.command, restartIn)
+				} else {
+					log.Infof("Restarting '%s' in %s due to upnormal exit status, trials: %d/%d", runner.command, restartIn, runs+1, runner.command.MaxRestart)
+				}
 			}
 		}
 
@@ -263,14 +462,26 @@ loop:
 			restartIn = time.Duration(runner.command.RecurringPeriod) * time.Second
 		}
 
+		if schedule != nil && !restarting {
+			now := time.Now()
+			next := schedule.Next(now)
+			if runner.command.CronOverlap == core.CronOverlapQueue && schedule.Next(fire).Before(now) {
+				//a fire time was missed while the job was running, run it once right now.
+				next = now
+			}
+
+			if !next.IsZero() {
+				fire = next
+				restarting = true
+				restartIn = next.Sub(now)
+			}
+		}
+
 		if restarting {
-			log.Infof("Recurring '%s' in %d", runner.command, restartIn)
-			select {
-			case <-time.After(restartIn):
-			case <-runner.kill:
-				log.Infof("Command %s Killed during scheduler sleep", runner.command)
+			log.Infof("Recurring '%s' in %s", runner.command, restartIn)
+			if !runner.sleep(restartIn) {
 				result.State = core.StateKilled
-				break loop
+				break
 			}
 		} else {
 			break
@@ -279,14 +490,70 @@ loop:
 
 }
 
+//sleep waits for the given duration, it returns false if the runner was killed meanwhile.
+func (runner *runnerImpl) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-runner.kill:
+		log.Infof("Command %s Killed during scheduler sleep", runner.command)
+		return false
+	}
+}
+
+//Kill kills the job, it doesn't block if the runner is already done.
 func (runner *runnerImpl) Kill() {
-	runner.kill <- 1
+	runner.request(runner.killRequest())
+}
+
+func (runner *runnerImpl) Terminate(sig syscall.Signal, grace time.Duration) {
+	req := runner.killRequest()
+	if sig != 0 {
+		req.signal = sig
+	}
+	if grace > 0 {
+		req.grace = grace
+	}
+
+	runner.request(req)
+}
+
+func (runner *runnerImpl) request(req *killRequest) {
+	select {
+	case runner.kill <- req:
+	case <-runner.done:
+	}
 }
 
 func (runner *runnerImpl) Process() process.Process {
+	runner.m.Lock()
+	defer runner.m.Unlock()
+
 	return runner.process
 }
 
+func (runner *runnerImpl) setProcess(ps process.Process) {
+	runner.m.Lock()
+	defer runner.m.Unlock()
+
+	runner.process = ps
+}
+
+func (runner *runnerImpl) Health() *core.HealthStatus {
+	if runner.health == nil {
+		return nil
+	}
+
+	return runner.health.Status()
+}
+
+//observe feeds an output line to the health check
+func (runner *runnerImpl) observe(line string) {
+	if runner.health != nil {
+		runner.health.observe(line)
+	}
+}
+
 func (runner *runnerImpl) Wait() *core.JobResult {
 	runner.wg.Wait()
 	return runner.result
@@ -301,6 +568,14 @@ func (runner *runnerImpl) Register(g process.GetPID) error {
 			return 0, err
 		}
 
+		//the process can't be reaped before the registration is complete, so
+		//it's safe to read its creation time here.
+		var createTime int64
+		if p, err := psutil.NewProcess(int32(pid)); err == nil {
+			createTime, _ = p.CreateTime()
+		}
+		runner.manager.journal.SetPID(runner.command, pid, createTime)
+
 		for _, hook := range runner.hooks {
 			go hook.PID(pid)
 		}
@@ -309,6 +584,6 @@ func (runner *runnerImpl) Register(g process.GetPID) error {
 	})
 }
 
-func (runner *runnerImpl) WaitPID(pid int) *syscall.WaitStatus {
+func (runner *runnerImpl) WaitPID(pid int) *process.ProcessState {
 	return runner.manager.WaitPID(pid)
 }
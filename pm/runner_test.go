@@ -0,0 +1,85 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunner_KillInternal(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	cmdMapMux.Lock()
+	CmdMap["test.block"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.block")
+
+	mgr := InitProcessManager(2)
+
+	killed, err := mgr.RunCmd(&core.Command{ID: "killed", Command: "test.block"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timedout, err := mgr.RunCmd(&core.Command{ID: "timedout", Command: "test.block", MaxTime: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	killed.Kill()
+
+	for runner, state := range map[Runner]string{killed: core.StateKilled, timedout: core.StateTimeout} {
+		done := make(chan *core.JobResult, 1)
+		go func() {
+			done <- runner.Wait()
+		}()
+
+		select {
+		case result := <-done:
+			if !assert.Equal(t, state, result.State) {
+				t.Fail()
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s is still waiting for the internal process", runner.Command())
+		}
+	}
+}
+
+func TestRunner_KillDone(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.done"] = process.NewInternalProcessFactory(func(cmd *core.Command) (interface{}, error) {
+		return nil, nil
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.done")
+
+	mgr := InitProcessManager(2)
+
+	runner, err := mgr.RunCmd(&core.Command{ID: "done", Command: "test.done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner.Wait()
+
+	//nothing takes the requests anymore, they must not block.
+	killed := make(chan struct{})
+	go func() {
+		runner.Kill()
+		runner.Terminate(syscall.SIGTERM, 0)
+		close(killed)
+	}()
+
+	select {
+	case <-killed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("kill blocked on a done runner")
+	}
+}
@@ -1,12 +1,22 @@
 package pm
 
 import (
+	"fmt"
 	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
 	"github.com/g8os/core.base/pm/stream"
+	"github.com/g8os/core.base/settings"
+	"os"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	readinessProbeTimeout = 1 * time.Second
+)
+
 type RunnerHook interface {
 	Tick(delay time.Duration)
 	Message(msg *stream.Message)
@@ -82,3 +92,114 @@ func (h *MatchHook) Message(msg *stream.Message) {
 		})
 	}
 }
+
+/*
+ReadinessHook calls Action(true) once all the readiness conditions are met, or Action(false) if they are not
+met within the readiness timeout. The conditions are probed on each tick (every second).
+*/
+type ReadinessHook struct {
+	NOOPHook
+	o sync.Once
+
+	ready   *settings.Readiness
+	table   process.PIDTable
+	pattern *regexp.Regexp
+	matched int32
+	probing int32
+	done    int32
+
+	Action func(ready bool)
+}
+
+//NewReadinessHook creates a readiness hook, the table is used to run the exec probe.
+func NewReadinessHook(ready *settings.Readiness, table process.PIDTable, action func(bool)) (*ReadinessHook, error) {
+	h := &ReadinessHook{
+		ready:  ready,
+		table:  table,
+		Action: action,
+	}
+
+	if ready.Match != "" {
+		var err error
+		if h.pattern, err = regexp.Compile(ready.Match); err != nil {
+			return nil, fmt.Errorf("invalid readiness pattern '%s': %s", ready.Match, err)
+		}
+	}
+
+	return h, nil
+}
+
+func (h *ReadinessHook) polled() bool {
+	return h.ready.TCP != "" || h.ready.Socket != "" || h.ready.File != "" || len(h.ready.Exec) > 0
+}
+
+//probe checks the polled conditions
+func (h *ReadinessHook) probe() error {
+	if h.ready.File != "" {
+		if _, err := os.Stat(h.ready.File); err != nil {
+			return err
+		}
+	}
+	if h.ready.Socket != "" {
+		if err := probeDial("unix", h.ready.Socket, readinessProbeTimeout); err != nil {
+			return err
+		}
+	}
+	if h.ready.TCP != "" {
+		if err := probeDial("tcp", h.ready.TCP, readinessProbeTimeout); err != nil {
+			return err
+		}
+	}
+	if len(h.ready.Exec) > 0 {
+		if err := probeExec(h.table, h.ready.Exec, readinessProbeTimeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *ReadinessHook) fire(ready bool) {
+	h.o.Do(func() {
+		atomic.StoreInt32(&h.done, 1)
+		h.Action(ready)
+	})
+}
+
+func (h *ReadinessHook) Tick(delay time.Duration) {
+	if atomic.LoadInt32(&h.done) == 1 {
+		return
+	}
+
+	if h.ready.Timeout > 0 && delay > time.Duration(h.ready.Timeout)*time.Second {
+		h.fire(false)
+		return
+	}
+
+	if h.pattern != nil && atomic.LoadInt32(&h.matched) == 0 {
+		return
+	}
+
+	//ticks are not waiting for each other, so a slow probe must not overlap with the next one.
+	if !atomic.CompareAndSwapInt32(&h.probing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&h.probing, 0)
+
+	if err := h.probe(); err != nil {
+		return
+	}
+
+	h.fire(true)
+}
+
+func (h *ReadinessHook) Message(msg *stream.Message) {
+	if h.pattern == nil || !h.pattern.MatchString(msg.Message) {
+		return
+	}
+
+	atomic.StoreInt32(&h.matched, 1)
+	if !h.polled() {
+		h.fire(true)
+	}
+}
@@ -0,0 +1,87 @@
+package pm
+
+import (
+	"github.com/g8os/core.base/pm/stream"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestReadinessHook_InvalidMatch(t *testing.T) {
+	if _, err := NewReadinessHook(&settings.Readiness{Match: "("}, nil, nil); !assert.Error(t, err) {
+		t.Fail()
+	}
+}
+
+func TestReadinessHook_Match(t *testing.T) {
+	var states []bool
+	hook, err := NewReadinessHook(&settings.Readiness{Match: `listening on \d+`}, nil, func(ready bool) {
+		states = append(states, ready)
+	})
+
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	hook.Message(&stream.Message{Message: "starting"})
+	hook.Tick(time.Second)
+	if !assert.Empty(t, states) {
+		t.Fail()
+	}
+
+	hook.Message(&stream.Message{Message: "listening on 8080"})
+	hook.Message(&stream.Message{Message: "listening on 8081"})
+	if !assert.Equal(t, []bool{true}, states) {
+		t.Fail()
+	}
+}
+
+func TestReadinessHook_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "ready")
+	var states []bool
+	hook, _ := NewReadinessHook(&settings.Readiness{File: file, Match: "up"}, nil, func(ready bool) {
+		states = append(states, ready)
+	})
+
+	ioutil.WriteFile(file, []byte{}, 0644)
+
+	//all conditions must be met
+	hook.Tick(time.Second)
+	if !assert.Empty(t, states) {
+		t.Fail()
+	}
+
+	hook.Message(&stream.Message{Message: "up"})
+	hook.Tick(2 * time.Second)
+	if !assert.Equal(t, []bool{true}, states) {
+		t.Fail()
+	}
+}
+
+func TestReadinessHook_Timeout(t *testing.T) {
+	var states []bool
+	hook, _ := NewReadinessHook(&settings.Readiness{TCP: "127.0.0.1:1", Timeout: 2}, nil, func(ready bool) {
+		states = append(states, ready)
+	})
+
+	hook.Tick(time.Second)
+	if !assert.Empty(t, states) {
+		t.Fail()
+	}
+
+	hook.Tick(3 * time.Second)
+	hook.Tick(4 * time.Second)
+	if !assert.Equal(t, []bool{false}, states) {
+		t.Fail()
+	}
+}
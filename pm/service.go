@@ -0,0 +1,483 @@
+package pm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/settings"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	//SourceInclude is the source of the services of the include dir
+	SourceInclude = "include"
+
+	//ServiceRunning the service has a running job
+	ServiceRunning = "running"
+	//ServiceStopped the service has no running job
+	ServiceStopped = "stopped"
+)
+
+//ServiceStatus is the runtime status of a startup service
+type ServiceStatus struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	State   string   `json:"state"`
+	Enabled bool     `json:"enabled"`
+	//Held the service was stopped explicitly, it's only started again explicitly
+	Held  bool     `json:"held,omitempty"`
+	After []string `json:"after,omitempty"`
+	//Result is the last result of a stopped service, if any
+	Result *core.JobResult `json:"result,omitempty"`
+}
+
+type serviceState struct {
+	Disabled []string `json:"disabled"`
+}
+
+/*
+ServiceRegistry keeps the startup services of the agent so they can be controlled at runtime. Services are
+still plain jobs (with the service name as job ID), the registry only knows how to start them again with their
+startup settings, and how to cascade over their dependencies.
+
+Services come from sources (the include dir, or a desired state pushed by the controller), each source is
+updated on its own, and a service name belongs to a single source.
+
+The enabled/disabled state of the services is optionally persisted in a file, a disabled service is not
+started on boot.
+*/
+type ServiceRegistry struct {
+	pm         *PM
+	sources    map[string][]settings.Startup
+	services   []settings.Startup
+	byName     map[string]settings.Startup
+	extensions map[string]settings.Extension
+
+	disabled map[string]bool
+	file     string
+	//held are the services that were stopped explicitly
+	held map[string]bool
+	m    sync.RWMutex
+
+	//start, stop and restart are serialized, so cascades don't interleave.
+	ops sync.Mutex
+}
+
+func newServiceRegistry(pm *PM) *ServiceRegistry {
+	return &ServiceRegistry{
+		pm:         pm,
+		sources:    make(map[string][]settings.Startup),
+		byName:     make(map[string]settings.Startup),
+		extensions: make(map[string]settings.Extension),
+		disabled:   make(map[string]bool),
+		held:       make(map[string]bool),
+	}
+}
+
+//setServices replaces the services of the source
+func (r *ServiceRegistry) setServices(source string, services []settings.Startup) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.sources[source] = services
+
+	names := make([]string, 0, len(r.sources))
+	for name := range r.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r.services = make([]settings.Startup, 0)
+	r.byName = make(map[string]settings.Startup)
+	for _, name := range names {
+		for _, service := range r.sources[name] {
+			r.services = append(r.services, service)
+			r.byName[service.Key()] = service
+		}
+	}
+}
+
+//owner gets the source of the service, if it's registered
+func (r *ServiceRegistry) owner(name string) (string, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	for source, services := range r.sources {
+		for _, service := range services {
+			if service.Key() == name {
+				return source, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+/*
+SetIncluded registers the extensions and the startup services of the included settings. If file is not empty,
+the enabled/disabled state of the services is loaded from (and saved to) that file.
+*/
+func (pm *PM) SetIncluded(included *settings.IncludedSettings, file string) error {
+	registry := newServiceRegistry(pm)
+	registry.file = file
+
+	if err := registry.load(); err != nil {
+		return err
+	}
+
+	if included != nil {
+		tree, errs := included.GetStartupTree()
+		for _, err := range errs {
+			log.Errorf("%s", err)
+		}
+
+		registry.extensions = included.Extension
+		registry.setServices(SourceInclude, tree.Services())
+
+		for name, ext := range included.Extension {
+			RegisterCmd(name, ext.Binary, ext.Cwd, ext.Args, ext.Env)
+		}
+	}
+
+	pm.services = registry
+	return nil
+}
+
+//Services gets the services registry
+func (pm *PM) Services() *ServiceRegistry {
+	return pm.services
+}
+
+func (r *ServiceRegistry) load() error {
+	if r.file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(r.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var state serviceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid services state file '%s': %s", r.file, err)
+	}
+
+	for _, name := range state.Disabled {
+		r.disabled[name] = true
+	}
+
+	return nil
+}
+
+//save persists the enabled/disabled state, must be called with the lock held.
+func (r *ServiceRegistry) save() error {
+	if r.file == "" {
+		return nil
+	}
+
+	state := serviceState{Disabled: make([]string, 0, len(r.disabled))}
+	for name := range r.disabled {
+		state.Disabled = append(state.Disabled, name)
+	}
+	sort.Strings(state.Disabled)
+
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	//write and rename, so a crash can't leave a truncated file behind.
+	tmp := r.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.file)
+}
+
+func (r *ServiceRegistry) get(name string) (settings.Startup, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	service, ok := r.byName[name]
+	if !ok {
+		return service, fmt.Errorf("unknown service '%s'", name)
+	}
+
+	return service, nil
+}
+
+//Disabled tells if the service is disabled
+func (r *ServiceRegistry) Disabled(name string) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.disabled[name]
+}
+
+func (r *ServiceRegistry) setEnabled(name string, enabled bool) error {
+	if _, err := r.get(name); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if enabled {
+		delete(r.disabled, name)
+	} else {
+		r.disabled[name] = true
+	}
+
+	return r.save()
+}
+
+//Enable enables the service, so it's started on boot
+func (r *ServiceRegistry) Enable(name string) error {
+	return r.setEnabled(name, true)
+}
+
+//Disable disables the service, so it's not started on boot. A running service is not stopped.
+func (r *ServiceRegistry) Disable(name string) error {
+	return r.setEnabled(name, false)
+}
+
+//Held tells if the service was stopped explicitly
+func (r *ServiceRegistry) Held(name string) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.held[name]
+}
+
+//keys gets the names of the services
+func keys(slice settings.StartupSlice) []string {
+	names := make([]string, 0, len(slice))
+	for _, service := range slice {
+		names = append(names, service.Key())
+	}
+
+	return names
+}
+
+//setHeld marks the services as stopped explicitly or not
+func (r *ServiceRegistry) setHeld(held bool, names ...string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, name := range names {
+		if held {
+			r.held[name] = true
+		} else {
+			delete(r.held, name)
+		}
+	}
+}
+
+/*
+ensurable tells if a stopped service can be started again by an ensure. A service that was stopped explicitly,
+or that was stopped because it kept crashing, is left alone until it's started explicitly.
+*/
+func (r *ServiceRegistry) ensurable(name string) bool {
+	if r.Held(name) {
+		return false
+	}
+
+	result, ok := r.pm.HistoryResult(name)
+	return !ok || result.State != core.StateCrashLoop
+}
+
+func (r *ServiceRegistry) running(name string) bool {
+	r.pm.runnersMux.Lock()
+	defer r.pm.runnersMux.Unlock()
+
+	_, ok := r.pm.runners[name]
+	return ok
+}
+
+//dependents gets the service and all the services that depend on it (directly or not).
+func (r *ServiceRegistry) dependents(name string) settings.StartupSlice {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	//a service and its dependencies can have the same weight, so the tree order can't be relied on.
+	set := map[string]bool{name: true}
+	for changed := true; changed; {
+		changed = false
+		for _, service := range r.services {
+			if set[service.Key()] {
+				continue
+			}
+
+			for _, dep := range service.After {
+				if set[dep] {
+					set[service.Key()] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	slice := make(settings.StartupSlice, 0)
+	for _, service := range r.services {
+		if set[service.Key()] {
+			slice = append(slice, service)
+		}
+	}
+
+	return slice
+}
+
+//checkDependencies makes sure the registered dependencies of the service are running
+func (r *ServiceRegistry) checkDependencies(service settings.Startup) error {
+	for _, dep := range service.After {
+		if _, err := r.get(dep); err == nil && !r.running(dep) {
+			return fmt.Errorf("dependency '%s' of service '%s' is not running", dep, service.Key())
+		}
+	}
+
+	return nil
+}
+
+/*
+Start starts a stopped service, even if it's disabled. The service dependencies must be running. It waits up to
+timeout (if not zero) for the service to be running.
+*/
+func (r *ServiceRegistry) Start(name string, timeout time.Duration) (*BootReport, error) {
+	r.ops.Lock()
+	defer r.ops.Unlock()
+
+	service, err := r.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.running(name) {
+		return nil, fmt.Errorf("service '%s' is already running", name)
+	}
+
+	if err := r.checkDependencies(service); err != nil {
+		return nil, err
+	}
+
+	r.setHeld(false, name)
+	return r.pm.runSlice(settings.StartupSlice{service}, timeout, nil, true), nil
+}
+
+//stop stops the service and its dependents, dependents first. It returns the names of the stopped services.
+func (r *ServiceRegistry) stop(name string) []string {
+	var services []settings.Startup
+	var stopped []string
+	for _, service := range r.dependents(name) {
+		if r.running(service.Key()) {
+			services = append(services, service)
+			stopped = append(stopped, service.Key())
+		}
+	}
+
+	r.pm.stopServices(context.Background(), services)
+	return stopped
+}
+
+/*
+Stop stops the service, all the running services that depend on it are stopped before it. The stopped services
+are held: they are not started again by an ensure, only by an explicit start or restart.
+*/
+func (r *ServiceRegistry) Stop(name string) ([]string, error) {
+	r.ops.Lock()
+	defer r.ops.Unlock()
+
+	if _, err := r.get(name); err != nil {
+		return nil, err
+	}
+
+	stopped := r.stop(name)
+	r.setHeld(true, append(stopped, name)...)
+
+	return stopped, nil
+}
+
+/*
+Restart stops the service (and its running dependents), then starts them again in dependency order. A stopped
+service is just started. It waits up to timeout (if not zero) for the services to be running.
+*/
+func (r *ServiceRegistry) Restart(name string, timeout time.Duration) (*BootReport, error) {
+	r.ops.Lock()
+	defer r.ops.Unlock()
+
+	service, err := r.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.checkDependencies(service); err != nil {
+		return nil, err
+	}
+
+	stopped := make(map[string]bool)
+	for _, name := range r.stop(name) {
+		stopped[name] = true
+	}
+
+	slice := settings.StartupSlice{service}
+	for _, dependent := range r.dependents(name) {
+		if dependent.Key() != name && stopped[dependent.Key()] {
+			slice = append(slice, dependent)
+		}
+	}
+
+	r.setHeld(false, keys(slice)...)
+	return r.pm.runSlice(slice, timeout, nil, true), nil
+}
+
+func (r *ServiceRegistry) status(service settings.Startup) *ServiceStatus {
+	status := &ServiceStatus{
+		Name:    service.Key(),
+		Command: service.Name,
+		State:   ServiceStopped,
+		Enabled: !r.Disabled(service.Key()),
+		Held:    r.Held(service.Key()),
+		After:   service.After,
+	}
+
+	if r.running(service.Key()) {
+		status.State = ServiceRunning
+	} else if result, ok := r.pm.HistoryResult(service.Key()); ok {
+		status.Result = result
+	}
+
+	return status
+}
+
+//Status gets the status of a service
+func (r *ServiceRegistry) Status(name string) (*ServiceStatus, error) {
+	service, err := r.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.status(service), nil
+}
+
+//List gets the status of all the services, in startup order
+func (r *ServiceRegistry) List() []*ServiceStatus {
+	r.m.RLock()
+	services := r.services
+	r.m.RUnlock()
+
+	statuses := make([]*ServiceStatus, 0, len(services))
+	for _, service := range services {
+		statuses = append(statuses, r.status(service))
+	}
+
+	return statuses
+}
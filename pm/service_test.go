@@ -0,0 +1,145 @@
+package pm
+
+import (
+	"context"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const testServices = `
+[startup.db]
+name = "core.system"
+
+[startup.app]
+name = "core.system"
+after = ["db"]
+
+[startup.web]
+name = "core.system"
+after = ["app"]
+
+[startup.cache]
+name = "core.system"
+`
+
+func testIncluded(t *testing.T, dir string) *settings.IncludedSettings {
+	include := path.Join(dir, "include")
+	os.Mkdir(include, 0755)
+	if err := ioutil.WriteFile(path.Join(include, "services.toml"), []byte(testServices), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	main := settings.AppSettings{}
+	main.Main.Include = include
+	included, errs := main.GetIncludedSettings()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	return included
+}
+
+func TestServices_Dependents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "services")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mgr := InitProcessManager(1)
+	if err := mgr.SetIncluded(testIncluded(t, dir), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, service := range mgr.Services().dependents("app") {
+		names = append(names, service.Key())
+	}
+
+	if !assert.ElementsMatch(t, []string{"app", "web"}, names) {
+		t.Fail()
+	}
+
+	if _, err := mgr.Services().Status("unknown"); !assert.Error(t, err) {
+		t.Fail()
+	}
+
+	//web can't start while app is not running
+	if _, err := mgr.Services().Start("web", 0); !assert.Error(t, err) {
+		t.Fail()
+	}
+}
+
+func TestServices_Enabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "services")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	included := testIncluded(t, dir)
+	file := path.Join(dir, "services.json")
+
+	mgr := InitProcessManager(1)
+	if err := mgr.SetIncluded(included, file); err != nil {
+		t.Fatal(err)
+	}
+
+	if !assert.NoError(t, mgr.Services().Disable("cache")) || !assert.Error(t, mgr.Services().Disable("unknown")) {
+		t.Fatal()
+	}
+
+	//the state survives a restart
+	if err := mgr.SetIncluded(included, file); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := mgr.Services().Status("cache")
+	if !assert.False(t, status.Enabled) || !assert.Equal(t, ServiceStopped, status.State) {
+		t.Fail()
+	}
+
+	if !assert.NoError(t, mgr.Services().Enable("cache")) || !assert.False(t, mgr.Services().Disabled("cache")) {
+		t.Fail()
+	}
+}
+
+func TestServices_RestartIdempotency(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.service"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.service")
+
+	mgr := InitProcessManager(2)
+	mgr.SetIdempotencyWindow(time.Minute)
+
+	slice := settings.StartupSlice{settings.Startup{Name: "test.service", RunningDelay: 1}.WithKey("service")}
+
+	//the service ID is in the completed jobs once it's stopped, it must still start again.
+	for i := 0; i < 2; i++ {
+		report := mgr.runSlice(slice, 5*time.Second, nil, false)
+		if !assert.True(t, report.OK(), "run %d", i) {
+			t.Fatal()
+		}
+
+		mgr.stopServices(context.Background(), slice)
+		if _, ok := mgr.Runner("service"); !assert.False(t, ok) {
+			t.Fatal()
+		}
+	}
+
+	//a controller command with the same ID is replayed
+	if _, err := mgr.RunCmd(&core.Command{ID: "service", Command: "test.service"}); !assert.Equal(t, CompletedIDErr, err) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,175 @@
+package pm
+
+import (
+	"context"
+	"fmt"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/settings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//ShuttingDown tells if the process manager is shutting down, and doesn't accept commands anymore.
+func (pm *PM) ShuttingDown() bool {
+	return atomic.LoadInt32(&pm.closing) == 1
+}
+
+//rejectClosing sends a cancelled result for the command if the process manager is shutting down
+func (pm *PM) rejectClosing(cmd *core.Command) bool {
+	if !pm.ShuttingDown() {
+		return false
+	}
+
+	pm.cancelCmd(cmd)
+	return true
+}
+
+func (pm *PM) cancelCmd(cmd *core.Command) {
+	log.Infof("Cancelling %s, the agent is shutting down", cmd)
+	result := core.NewBasicJobResult(cmd)
+	result.State = core.StateCancelled
+	result.Data = ShuttingDownErr.Error()
+
+	pm.journal.Drop(cmd)
+	pm.resultCallback(cmd, result)
+}
+
+/*
+Shutdown stops the process manager gracefully:
+	- no more commands are accepted, the sinks stop polling.
+	- the commands that are waiting for a job slot or on a queue are cancelled, each with a CANCELLED result.
+	- the running jobs that are not startup services are terminated.
+	- the startup services of the tree are terminated in reverse dependency order, a service is only stopped
+	  after all the services that run after it are stopped.
+
+Each job is terminated with its kill signal and grace period. If the whole sequence takes longer than
+timeout (if not zero), whatever is still running is SIGKILLed and an error is returned.
+*/
+func (pm *PM) Shutdown(tree settings.StartupTree, timeout time.Duration) error {
+	if !atomic.CompareAndSwapInt32(&pm.closing, 0, 1) {
+		return fmt.Errorf("process manager is already shutting down")
+	}
+
+	log.Infof("Shutting down the process manager")
+	pm.cancel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer cancel()
+
+	pm.jobsCond.L.Lock()
+	cancelled := make([]*core.Command, 0, pm.pending.Len())
+	for pm.pending.Len() > 0 {
+		cancelled = append(cancelled, pm.pending.pop())
+	}
+	pm.jobsCond.L.Unlock()
+
+	cancelled = append(cancelled, pm.queueMgr.Drain()...)
+	for _, cmd := range cancelled {
+		pm.cancelCmd(cmd)
+	}
+
+	var services []settings.Startup
+	if tree != nil {
+		services = tree.Services()
+	}
+
+	isService := make(map[string]bool)
+	for _, service := range services {
+		isService[service.Key()] = true
+	}
+
+	var jobs []string
+	pm.runnersMux.Lock()
+	for id := range pm.runners {
+		if !isService[id] {
+			jobs = append(jobs, id)
+		}
+	}
+	pm.runnersMux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, id := range jobs {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			pm.stopJob(ctx, id)
+		}(id)
+	}
+	wg.Wait()
+
+	pm.stopServices(ctx, services)
+
+	if ctx.Err() == nil {
+		log.Infof("Process manager shutdown completed")
+		return nil
+	}
+
+	var left []string
+	pm.runnersMux.Lock()
+	for id, runner := range pm.runners {
+		left = append(left, id)
+		go runner.Terminate(syscall.SIGKILL, 0)
+	}
+	pm.runnersMux.Unlock()
+
+	return fmt.Errorf("shutdown timed out, killed %v", left)
+}
+
+//stopServices stops the services, each after all the services that depend on it.
+func (pm *PM) stopServices(ctx context.Context, services []settings.Startup) {
+	dependents := make(map[string][]string)
+	for _, service := range services {
+		for _, dep := range service.After {
+			dependents[dep] = append(dependents[dep], service.Key())
+		}
+	}
+
+	stopped := NewStateMachine()
+	defer stopped.Stop()
+
+	var wg sync.WaitGroup
+	for _, service := range services {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := stopped.WaitContext(ctx, dependents[id]...); err != nil {
+				return
+			}
+
+			log.Infof("Stopping service %s", id)
+			pm.stopJob(ctx, id)
+			stopped.Release(id, true)
+		}(service.Key())
+	}
+
+	wg.Wait()
+}
+
+//stopJob terminates a job and waits for it to exit, it gives up waiting when the context is done.
+func (pm *PM) stopJob(ctx context.Context, id string) {
+	pm.runnersMux.Lock()
+	runner, ok := pm.runners[id]
+	pm.runnersMux.Unlock()
+
+	if !ok {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runner.Wait()
+		close(done)
+	}()
+
+	runner.Kill()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,257 @@
+package pm
+
+import (
+	"context"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/pm/stream"
+	"github.com/g8os/core.base/settings"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//testTree is a startup tree of the given services
+type testTree []settings.Startup
+
+func (t testTree) Services() []settings.Startup {
+	return t
+}
+
+func (t testTree) Slice(s, e int64) settings.StartupSlice {
+	return settings.StartupSlice(t)
+}
+
+//stubbornProcess ignores all the signals, it only exits when it's killed.
+type stubbornProcess struct {
+	cmd     *core.Command
+	signals []syscall.Signal
+	killed  chan struct{}
+	once    sync.Once
+	m       sync.Mutex
+}
+
+func (p *stubbornProcess) Command() *core.Command {
+	return p.cmd
+}
+
+func (p *stubbornProcess) Run() (<-chan *stream.Message, error) {
+	channel := make(chan *stream.Message)
+	go func() {
+		defer close(channel)
+		<-p.killed
+		channel <- stream.MessageExitError
+	}()
+
+	return channel, nil
+}
+
+func (p *stubbornProcess) Signal(sig syscall.Signal) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if sig != 0 {
+		p.signals = append(p.signals, sig)
+	}
+	return nil
+}
+
+func (p *stubbornProcess) Kill() {
+	p.once.Do(func() {
+		close(p.killed)
+	})
+}
+
+func (p *stubbornProcess) GetStats() *process.ProcessStats {
+	return &process.ProcessStats{Cmd: p.cmd}
+}
+
+//waitUntil polls until cond is true
+func waitUntil(t *testing.T, cond func() bool) {
+	timeout := time.After(5 * time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			t.Fatal("timed out")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestShutdown_RejectsCommands(t *testing.T) {
+	mgr := InitProcessManager(1)
+
+	results := make(chan *core.JobResult, 1)
+	mgr.AddResultHandler(func(cmd *core.Command, result *core.JobResult) {
+		results <- result
+	})
+
+	if !assert.NoError(t, mgr.Shutdown(nil, time.Second)) || !assert.True(t, mgr.ShuttingDown()) {
+		t.Fatal()
+	}
+
+	mgr.PushCmd(&core.Command{ID: "late", Command: "core.ping"})
+
+	select {
+	case result := <-results:
+		if !assert.Equal(t, core.StateCancelled, result.State) {
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result for the rejected command")
+	}
+
+	if !assert.Error(t, mgr.Shutdown(nil, time.Second)) {
+		t.Fail()
+	}
+}
+
+func TestShutdown_CancelsWaiting(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.block"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.block")
+
+	mgr := InitProcessManager(1)
+	go mgr.processCmds()
+	go mgr.processQueues()
+
+	var m sync.Mutex
+	results := make(map[string]string)
+	mgr.AddResultHandler(func(cmd *core.Command, result *core.JobResult) {
+		m.Lock()
+		defer m.Unlock()
+		results[result.ID] = result.State
+	})
+
+	//running takes the only job slot, pending and first wait for it, and second waits for first on the queue.
+	mgr.PushCmd(&core.Command{ID: "running", Command: "test.block"})
+	waitUntil(t, func() bool {
+		_, ok := mgr.Runner("running")
+		return ok
+	})
+
+	mgr.PushCmd(&core.Command{ID: "pending", Command: "test.block"})
+	mgr.PushCmdToQueue(&core.Command{ID: "first", Command: "test.block", Queue: "queue"})
+	mgr.PushCmdToQueue(&core.Command{ID: "second", Command: "test.block", Queue: "queue"})
+	waitUntil(t, func() bool {
+		mgr.jobsCond.L.Lock()
+		pending := mgr.pending.Len()
+		mgr.jobsCond.L.Unlock()
+
+		mgr.queueMgr.lock.Lock()
+		defer mgr.queueMgr.lock.Unlock()
+		queue, ok := mgr.queueMgr.queues["queue"]
+		return pending == 2 && ok && queue.cmds.Len() == 1
+	})
+
+	if !assert.NoError(t, mgr.Shutdown(nil, 5*time.Second)) {
+		t.Fail()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	expected := map[string]string{
+		"running": core.StateKilled,
+		"pending": core.StateCancelled,
+		"first":   core.StateCancelled,
+		"second":  core.StateCancelled,
+	}
+	if !assert.Equal(t, expected, results) {
+		t.Fail()
+	}
+}
+
+func TestShutdown_ServicesOrder(t *testing.T) {
+	cmdMapMux.Lock()
+	CmdMap["test.service"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.service")
+
+	mgr := InitProcessManager(10)
+
+	var m sync.Mutex
+	var stopped []string
+	mgr.AddResultHandler(func(cmd *core.Command, result *core.JobResult) {
+		m.Lock()
+		defer m.Unlock()
+		stopped = append(stopped, result.ID)
+	})
+
+	tree := testTree{
+		settings.Startup{Name: "test.service"}.WithKey("db"),
+		settings.Startup{Name: "test.service", After: []string{"db"}}.WithKey("app"),
+		settings.Startup{Name: "test.service", After: []string{"app"}}.WithKey("web"),
+	}
+
+	for _, service := range tree {
+		if _, err := mgr.runCmd(&core.Command{ID: service.Key(), Command: service.Name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !assert.NoError(t, mgr.Shutdown(tree, 5*time.Second)) {
+		t.Fail()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if !assert.Equal(t, []string{"web", "app", "db"}, stopped) {
+		t.Fail()
+	}
+}
+
+func TestShutdown_Timeout(t *testing.T) {
+	var ps *stubbornProcess
+	cmdMapMux.Lock()
+	CmdMap["test.stubborn"] = func(_ process.PIDTable, cmd *core.Command) process.Process {
+		ps = &stubbornProcess{cmd: cmd, killed: make(chan struct{})}
+		return ps
+	}
+	cmdMapMux.Unlock()
+	defer UnregisterCmd("test.stubborn")
+
+	mgr := InitProcessManager(1)
+
+	//the grace period is way longer than the shutdown timeout
+	runner, err := mgr.RunCmd(&core.Command{ID: "stubborn", Command: "test.stubborn", KillGrace: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, func() bool {
+		return runner.Process() != nil
+	})
+
+	err = mgr.Shutdown(nil, 200*time.Millisecond)
+	if !assert.Error(t, err) || !assert.Contains(t, err.Error(), "stubborn") {
+		t.Fail()
+	}
+
+	done := make(chan *core.JobResult)
+	go func() {
+		done <- runner.Wait()
+	}()
+
+	select {
+	case result := <-done:
+		if !assert.Equal(t, core.StateKilled, result.State) {
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job was not killed after the shutdown timeout")
+	}
+
+	ps.m.Lock()
+	defer ps.m.Unlock()
+	if !assert.Equal(t, []syscall.Signal{syscall.SIGTERM}, ps.signals) {
+		t.Fail()
+	}
+}
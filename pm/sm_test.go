@@ -1,6 +1,7 @@
 package pm
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"sync"
@@ -176,3 +177,88 @@ func Test_BlockingWait_ComplexDependency(t *testing.T) {
 		t.Fatal("Timedout")
 	}
 }
+
+func Test_WaitContext_Timeout(t *testing.T) {
+	state := NewStateMachine()
+	state.Release("init", true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	s, err := state.WaitContext(ctx, "init", "net")
+	if !assert.Equal(t, context.DeadlineExceeded, err) || !assert.False(t, s) {
+		t.Fail()
+	}
+
+	//the timed out request must not be satisfied later.
+	state.Release("net", true)
+	s, err = state.WaitContext(context.Background(), "init", "net")
+	if !assert.NoError(t, err) || !assert.True(t, s) {
+		t.Fail()
+	}
+}
+
+func Test_Blocking(t *testing.T) {
+	state := NewStateMachine()
+	state.Release("a", true)
+	state.Release("b", false)
+
+	//release is async, wait until it's applied
+	state.Wait("a", "b")
+
+	failed, pending := state.Blocking("a", "b", "c")
+	if !assert.Equal(t, []string{"b"}, failed) || !assert.Equal(t, []string{"c"}, pending) {
+		t.Fail()
+	}
+}
+
+func Test_Expire(t *testing.T) {
+	state := NewStateMachine()
+
+	state.Release("done", true)
+	state.Release("needed", true)
+
+	result := make(chan bool)
+	go func() {
+		result <- state.Wait("needed", "pending")
+	}()
+
+	//let the request register
+	time.Sleep(100 * time.Millisecond)
+	state.Expire(time.Now())
+
+	if _, pending := state.Blocking("done", "needed"); !assert.Equal(t, []string{"done"}, pending) {
+		t.Fail()
+	}
+
+	state.Release("pending", true)
+	if !assert.True(t, <-result) {
+		t.Fail()
+	}
+}
+
+func Test_Stop(t *testing.T) {
+	state := NewStateMachine()
+	state.Release("a", true)
+	state.Wait("a")
+
+	state.Stop()
+	state.Stop()
+
+	released := make(chan struct{})
+	go func() {
+		//nothing takes the release anymore, it must not block.
+		state.Release("b", true)
+		close(released)
+	}()
+
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("release blocked on a stopped state machine")
+	}
+
+	if _, pending := state.Blocking("a", "b"); !assert.Equal(t, []string{"b"}, pending) {
+		t.Fail()
+	}
+}
@@ -1,12 +1,22 @@
 package pm
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
 type StateMachine interface {
 	Wait(key ...string) bool
+	//WaitContext is like Wait, but gives up with the context error once the context is done
+	WaitContext(ctx context.Context, key ...string) (bool, error)
 	Release(key string, s bool)
+	//Blocking gets the keys that were released with a false state, and the keys that are not released yet
+	Blocking(key ...string) (failed []string, pending []string)
+	//Expire forgets the keys that were released before the given time, unless a waiting request needs them
+	Expire(before time.Time)
+	//Stop stops the state machine routine, the keys released after are ignored
+	Stop()
 }
 
 type releaseReq struct {
@@ -20,19 +30,24 @@ type waitReq struct {
 }
 
 type stateMachineImpl struct {
-	states map[string]bool
+	states   map[string]bool
+	released map[string]time.Time
 
 	waiting []waitReq
 	rch     chan *releaseReq
+	done    chan struct{}
+	once    sync.Once
 
 	m sync.Mutex
 }
 
 func NewStateMachine() StateMachine {
 	s := &stateMachineImpl{
-		states:  make(map[string]bool),
-		waiting: make([]waitReq, 0),
-		rch:     make(chan *releaseReq),
+		states:   make(map[string]bool),
+		released: make(map[string]time.Time),
+		waiting:  make([]waitReq, 0),
+		rch:      make(chan *releaseReq),
+		done:     make(chan struct{}),
 	}
 
 	go s.loop()
@@ -42,8 +57,16 @@ func NewStateMachine() StateMachine {
 
 func (s *stateMachineImpl) loop() {
 	for {
-		r := <-s.rch
+		var r *releaseReq
+		select {
+		case r = <-s.rch:
+		case <-s.done:
+			return
+		}
+
+		s.m.Lock()
 		s.states[r.k] = r.s
+		s.released[r.k] = time.Now()
 
 		//check waiting requests
 		for i := len(s.waiting) - 1; i >= 0; i-- {
@@ -56,10 +79,9 @@ func (s *stateMachineImpl) loop() {
 			//all the request key has been satisfied
 			wrq.ch <- state
 
-			s.m.Lock()
 			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
-			s.m.Unlock()
 		}
+		s.m.Unlock()
 	}
 }
 
@@ -81,8 +103,17 @@ func (s *stateMachineImpl) satisfied(keys []string) (state bool, ok bool) {
 }
 
 func (s *stateMachineImpl) Wait(keys ...string) bool {
+	state, _ := s.WaitContext(context.Background(), keys...)
+	return state
+}
+
+func (s *stateMachineImpl) WaitContext(ctx context.Context, keys ...string) (bool, error) {
+	//states and waiting requests are checked under the same lock, so a release
+	//can't happen between the check and the registration of the request.
+	s.m.Lock()
 	if state, ok := s.satisfied(keys); ok {
-		return state
+		s.m.Unlock()
+		return state, nil
 	}
 	wrq := waitReq{
 		keys: keys,
@@ -93,16 +124,72 @@ func (s *stateMachineImpl) Wait(keys ...string) bool {
 		close(wrq.ch)
 	}()
 
-	s.m.Lock()
 	s.waiting = append(s.waiting, wrq)
 	s.m.Unlock()
 
-	return <-wrq.ch
+	select {
+	case state := <-wrq.ch:
+		return state, nil
+	case <-ctx.Done():
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	for i, w := range s.waiting {
+		if w.ch == wrq.ch {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return false, ctx.Err()
+		}
+	}
+
+	//the request was satisfied in the meantime, the state is already in the (buffered) channel.
+	return <-wrq.ch, nil
+}
+
+func (s *stateMachineImpl) Blocking(keys ...string) (failed []string, pending []string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, k := range keys {
+		if state, ok := s.states[k]; !ok {
+			pending = append(pending, k)
+		} else if !state {
+			failed = append(failed, k)
+		}
+	}
+
+	return
 }
 
 func (s *stateMachineImpl) Release(key string, state bool) {
-	s.rch <- &releaseReq{
-		k: key,
-		s: state,
+	//hooks can release keys long after the state machine is stopped, they must not block.
+	select {
+	case s.rch <- &releaseReq{k: key, s: state}:
+	case <-s.done:
 	}
-}
\ No newline at end of file
+}
+
+func (s *stateMachineImpl) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *stateMachineImpl) Expire(before time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	needed := make(map[string]bool)
+	for _, wrq := range s.waiting {
+		for _, k := range wrq.keys {
+			needed[k] = true
+		}
+	}
+
+	for k, at := range s.released {
+		if at.Before(before) && !needed[k] {
+			delete(s.states, k)
+			delete(s.released, k)
+		}
+	}
+}
@@ -73,6 +73,18 @@ type Message struct {
 	Level   int
 	Message string
 	Epoch   int64
+
+	//Exit is set on exit state messages of processes that has an exit status.
+	Exit *core.ExitStatus
+}
+
+//MessageExit creates an exit state message that carries the process exit status
+func MessageExit(state string, exit *core.ExitStatus) *Message {
+	return &Message{
+		Level:   LevelExitState,
+		Message: state,
+		Exit:    exit,
+	}
 }
 
 //MessageHandler represents a callback type
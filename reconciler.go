@@ -0,0 +1,304 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/settings"
+	"github.com/garyburd/redigo/redis"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultReconcileInterval = 10 * time.Second
+
+	//reconcilerSource is the source of the desired services in the services registry
+	reconcilerSource = "desired"
+	//reconcileTimeout is how long a reconciliation waits for the (re)started services to be running
+	reconcileTimeout = 60 * time.Second
+)
+
+const (
+	//DriftMissing the desired service is new
+	DriftMissing = "missing"
+	//DriftChanged the desired service definition changed
+	DriftChanged = "changed"
+	//DriftStopped the desired service is not running
+	DriftStopped = "stopped"
+	//DriftHeld the desired service is not running because it was stopped explicitly, it's not started again
+	DriftHeld = "held"
+	//DriftCrashLoop the desired service is not running because it kept crashing, it's not started again
+	DriftCrashLoop = "crashloop"
+	//DriftUnwanted the service is running but is not desired anymore
+	DriftUnwanted = "unwanted"
+)
+
+type Reconciler interface {
+	Run()
+}
+
+//ServiceReport is the reconciliation status of a desired service, as reported to redis
+type ServiceReport struct {
+	Status *pm.ServiceStatus `json:"status,omitempty"`
+	//Drift is how the service differed from its desired state before the reconciliation, if it did
+	Drift string `json:"drift,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+//ReconcileReport is the outcome of a reconciliation, as reported to redis
+type ReconcileReport struct {
+	Time  int64             `json:"time"`
+	Drift map[string]string `json:"drift"`
+	Apply *pm.ReloadReport  `json:"apply,omitempty"`
+}
+
+/*
+reconcilerImpl converges the services of the agent to the desired state published by the controller. The desired
+state is a redis hash (core:<id>:desired) with a field per service and the service definition (in the startup
+service shape) as json value. The status of each desired service is reported in the core:<id>:services hash, and the
+last reconciliation report in core:<id>:reconcile.
+*/
+type reconcilerImpl struct {
+	mgr      *pm.PM
+	pool     *redis.Pool
+	id       string
+	interval time.Duration
+
+	applied map[string]settings.Startup
+}
+
+/*
+NewReconciler creates a reconciler that reads the desired services from the given sink, every interval. The
+desired state is also applied to the agent right away when Run is called.
+*/
+func NewReconciler(mgr *pm.PM, cfg *settings.SinkConfig, id string, interval time.Duration) (Reconciler, error) {
+	pool, err := newSinkPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = DefaultReconcileInterval
+	}
+
+	return &reconcilerImpl{
+		mgr:      mgr,
+		pool:     pool,
+		id:       id,
+		interval: interval,
+		applied:  make(map[string]settings.Startup),
+	}, nil
+}
+
+func (r *reconcilerImpl) key(name string) string {
+	return fmt.Sprintf("core:%s:%s", r.id, name)
+}
+
+//load gets the desired services, and the errors of the services that can't be decoded
+func (r *reconcilerImpl) load() (map[string]settings.Startup, map[string]string, error) {
+	db := r.pool.Get()
+	defer db.Close()
+
+	values, err := redis.StringMap(db.Do("HGETALL", r.key("desired")))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	desired := make(map[string]settings.Startup)
+	invalid := make(map[string]string)
+	for name, value := range values {
+		var service settings.Startup
+		if err := json.Unmarshal([]byte(value), &service); err != nil {
+			invalid[name] = fmt.Sprintf("invalid service definition: %s", err)
+			continue
+		}
+		if service.Name == "" {
+			invalid[name] = "invalid service definition: missing name"
+			continue
+		}
+
+		desired[name] = service.WithKey(name)
+	}
+
+	return desired, invalid, nil
+}
+
+//stoppedDrift tells why a desired service is not running
+func stoppedDrift(status *pm.ServiceStatus) string {
+	switch {
+	case status == nil:
+		return DriftStopped
+	case status.Held:
+		return DriftHeld
+	case status.Result != nil && status.Result.State == core.StateCrashLoop:
+		return DriftCrashLoop
+	}
+
+	return DriftStopped
+}
+
+//drift compares the running services with the desired services
+func (r *reconcilerImpl) drift(desired map[string]settings.Startup) map[string]string {
+	drift := make(map[string]string)
+	for name, service := range desired {
+		applied, ok := r.applied[name]
+		status, err := r.mgr.Services().Status(name)
+
+		switch {
+		case !ok:
+			drift[name] = DriftMissing
+		case !reflect.DeepEqual(applied, service):
+			drift[name] = DriftChanged
+		case err != nil || status.State != pm.ServiceRunning:
+			drift[name] = stoppedDrift(status)
+		}
+	}
+
+	for name := range r.applied {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		if status, err := r.mgr.Services().Status(name); err == nil && status.State == pm.ServiceRunning {
+			drift[name] = DriftUnwanted
+		}
+	}
+
+	return drift
+}
+
+func (r *reconcilerImpl) reconcile() {
+	report := &ReconcileReport{
+		Time: time.Now().Unix(),
+	}
+
+	desired, invalid, err := r.load()
+	if err != nil {
+		//the desired state is unknown, so the running services are left alone.
+		log.Errorf("Failed to load the desired services: %s", err)
+		return
+	}
+
+	report.Drift = r.drift(desired)
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := make([]settings.Startup, 0, len(names))
+	for _, name := range names {
+		services = append(services, desired[name])
+	}
+
+	if len(report.Drift) > 0 {
+		log.Infof("Services drifted from the desired state: %v", report.Drift)
+	}
+
+	//the services that are not running are started again even if nothing changed, that's what makes the
+	//reconciliation continuous. The held and crash looping services are only reported as drift.
+	report.Apply = r.mgr.Services().Apply(reconcilerSource, services, reconcileTimeout, true)
+
+	failures := make(map[string]string)
+	for name, err := range invalid {
+		failures[name] = err
+	}
+	for _, name := range report.Apply.Conflicts {
+		failures[name] = "a service with the same name is already defined by the agent"
+	}
+
+	//the conflicting services are still reported as desired, but they were not applied.
+	r.applied = make(map[string]settings.Startup)
+	for name, service := range desired {
+		if _, conflict := failures[name]; !conflict {
+			r.applied[name] = service
+		}
+	}
+	if report.Apply.Boot != nil {
+		for _, service := range report.Apply.Boot.Services {
+			if service.State != pm.BootStarted {
+				failures[service.ID] = fmt.Sprintf("service %s %s", service.State, service.Error)
+			}
+		}
+	}
+
+	if err := r.report(desired, invalid, failures, report); err != nil {
+		log.Errorf("Failed to report the services status: %s", err)
+	}
+}
+
+func (r *reconcilerImpl) report(desired map[string]settings.Startup, invalid map[string]string, failures map[string]string, report *ReconcileReport) error {
+	db := r.pool.Get()
+	defer db.Close()
+
+	statusKey := r.key("services")
+	reported := make(map[string]bool)
+
+	write := func(name string, service *ServiceReport) error {
+		reported[name] = true
+		data, err := json.Marshal(service)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Do("HSET", statusKey, name, data)
+		return err
+	}
+
+	for name := range invalid {
+		if err := write(name, &ServiceReport{Error: failures[name]}); err != nil {
+			return err
+		}
+	}
+
+	for name := range desired {
+		service := &ServiceReport{
+			Drift: report.Drift[name],
+			Error: failures[name],
+		}
+
+		if status, err := r.mgr.Services().Status(name); err == nil {
+			service.Status = status
+		}
+
+		if err := write(name, service); err != nil {
+			return err
+		}
+	}
+
+	//forget about the services that are not desired anymore
+	names, err := redis.Strings(db.Do("HKEYS", statusKey))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !reported[name] {
+			if _, err := db.Do("HDEL", statusKey, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Do("SET", r.key("reconcile"), data)
+	return err
+}
+
+func (r *reconcilerImpl) run() {
+	for !r.mgr.ShuttingDown() {
+		r.reconcile()
+		time.Sleep(r.interval)
+	}
+}
+
+func (r *reconcilerImpl) Run() {
+	go r.run()
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/g8os/core.base/pm"
+	"github.com/g8os/core.base/pm/core"
+	"github.com/g8os/core.base/pm/process"
+	"github.com/g8os/core.base/settings"
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	//test.service runs until it's killed
+	pm.CmdMap["test.service"] = process.NewInternalContextProcessFactory(func(ctx context.Context, cmd *core.Command) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+}
+
+//testRedis is an in memory redis with the few commands the reconciler uses
+type testRedis struct {
+	hashes  map[string]map[string]string
+	strings map[string]string
+	err     error
+	m       sync.Mutex
+}
+
+func newTestRedis() *testRedis {
+	return &testRedis{
+		hashes:  make(map[string]map[string]string),
+		strings: make(map[string]string),
+	}
+}
+
+func (r *testRedis) hash(key string) map[string]string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	hash := make(map[string]string)
+	for k, v := range r.hashes[key] {
+		hash[k] = v
+	}
+
+	return hash
+}
+
+func (r *testRedis) set(key, field, value string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.hashes[key] == nil {
+		r.hashes[key] = make(map[string]string)
+	}
+	r.hashes[key][field] = value
+}
+
+type testConn struct {
+	db *testRedis
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Err() error {
+	return nil
+}
+
+func (c *testConn) Do(name string, args ...interface{}) (interface{}, error) {
+	db := c.db
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	if db.err != nil {
+		return nil, db.err
+	}
+
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = fmt.Sprintf("%s", arg)
+	}
+
+	switch name {
+	case "HGETALL":
+		var reply []interface{}
+		for k, v := range db.hashes[strs[0]] {
+			reply = append(reply, []byte(k), []byte(v))
+		}
+		return reply, nil
+	case "HKEYS":
+		var reply []interface{}
+		for k := range db.hashes[strs[0]] {
+			reply = append(reply, []byte(k))
+		}
+		return reply, nil
+	case "HSET":
+		if db.hashes[strs[0]] == nil {
+			db.hashes[strs[0]] = make(map[string]string)
+		}
+		db.hashes[strs[0]][strs[1]] = strs[2]
+		return int64(1), nil
+	case "HDEL":
+		delete(db.hashes[strs[0]], strs[1])
+		return int64(1), nil
+	case "SET":
+		db.strings[strs[0]] = strs[1]
+		return "OK", nil
+	}
+
+	return nil, fmt.Errorf("unsupported command %s", name)
+}
+
+func (c *testConn) Send(name string, args ...interface{}) error {
+	return fmt.Errorf("not supported")
+}
+
+func (c *testConn) Flush() error {
+	return nil
+}
+
+func (c *testConn) Receive() (interface{}, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func testReconciler(t *testing.T, db *testRedis) *reconcilerImpl {
+	//web is defined by the agent itself
+	dir, err := ioutil.TempDir("", "reconciler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "web.toml"), []byte("[startup.web]\nname = \"test.service\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	main := settings.AppSettings{}
+	main.Main.Include = dir
+	included, errs := main.GetIncludedSettings()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	mgr := pm.InitProcessManager(10)
+	//the services are started again with the same job ID, they must not be replayed.
+	mgr.SetIdempotencyWindow(time.Minute)
+	if err := mgr.SetIncluded(included, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	return &reconcilerImpl{
+		mgr: mgr,
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return &testConn{db: db}, nil
+			},
+		},
+		id:       "test",
+		interval: time.Second,
+		applied:  make(map[string]settings.Startup),
+	}
+}
+
+func TestReconciler_Load(t *testing.T) {
+	db := newTestRedis()
+	r := testReconciler(t, db)
+
+	db.set("core:test:desired", "app", `{"name": "test.service", "after": ["db"]}`)
+	db.set("core:test:desired", "broken", `{"name": `)
+	db.set("core:test:desired", "unnamed", `{"after": ["db"]}`)
+
+	desired, invalid, err := r.load()
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	if !assert.Len(t, desired, 1) || !assert.Equal(t, "app", desired["app"].Key()) || !assert.Equal(t, []string{"db"}, desired["app"].After) {
+		t.Fail()
+	}
+
+	if !assert.Len(t, invalid, 2) || !assert.Contains(t, invalid["unnamed"], "missing name") {
+		t.Fail()
+	}
+
+	//the desired state is unknown if redis fails
+	db.err = fmt.Errorf("connection refused")
+	if _, _, err := r.load(); !assert.Error(t, err) {
+		t.Fail()
+	}
+}
+
+func TestReconciler_Drift(t *testing.T) {
+	r := testReconciler(t, newTestRedis())
+
+	service := func(name string, args map[string]interface{}) settings.Startup {
+		return settings.Startup{Name: "test.service", Args: args}.WithKey(name)
+	}
+
+	r.applied = map[string]settings.Startup{
+		"same":    service("same", nil),
+		"changed": service("changed", nil),
+		"removed": service("removed", nil),
+	}
+
+	drift := r.drift(map[string]settings.Startup{
+		"same":    service("same", nil),
+		"changed": service("changed", map[string]interface{}{"port": 80}),
+		"new":     service("new", nil),
+	})
+
+	//the removed service is not running, so there is nothing to stop.
+	if !assert.Equal(t, map[string]string{
+		"same":    DriftStopped,
+		"changed": DriftChanged,
+		"new":     DriftMissing,
+	}, drift) {
+		t.Fail()
+	}
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	db := newTestRedis()
+	r := testReconciler(t, db)
+
+	db.set("core:test:desired", "app", `{"name": "test.service", "running_delay": 1}`)
+	db.set("core:test:desired", "web", `{"name": "test.service", "running_delay": 1}`)
+	db.set("core:test:desired", "broken", `{`)
+
+	status := func(name string) *ServiceReport {
+		var report ServiceReport
+		data, ok := db.hash("core:test:services")[name]
+		if !assert.True(t, ok, name) || !assert.NoError(t, json.Unmarshal([]byte(data), &report)) {
+			t.Fatal()
+		}
+		return &report
+	}
+
+	r.reconcile()
+
+	app := status("app")
+	if !assert.Equal(t, DriftMissing, app.Drift) || !assert.Empty(t, app.Error) || !assert.Equal(t, pm.ServiceRunning, app.Status.State) {
+		t.Fail()
+	}
+
+	//web is owned by the agent, the desired definition is not applied.
+	if !assert.Contains(t, status("web").Error, "already defined") || !assert.Contains(t, status("broken").Error, "invalid") {
+		t.Fail()
+	}
+	if _, ok := r.applied["web"]; !assert.False(t, ok) {
+		t.Fail()
+	}
+
+	//a service stopped explicitly is not started again
+	if _, err := r.mgr.Services().Stop("app"); err != nil {
+		t.Fatal(err)
+	}
+
+	r.reconcile()
+
+	app = status("app")
+	if !assert.Equal(t, DriftHeld, app.Drift) || !assert.Equal(t, pm.ServiceStopped, app.Status.State) || !assert.True(t, app.Status.Held) {
+		t.Fail()
+	}
+
+	//until it's started explicitly
+	if _, err := r.mgr.Services().Start("app", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	r.reconcile()
+
+	app = status("app")
+	if !assert.Empty(t, app.Drift) || !assert.Empty(t, app.Error) || !assert.Equal(t, pm.ServiceRunning, app.Status.State) {
+		t.Fail()
+	}
+
+	//a service that is stopped in any other way is started again
+	if runner, ok := r.mgr.Runner("app"); ok {
+		runner.Kill()
+		runner.Wait()
+	}
+
+	r.reconcile()
+
+	app = status("app")
+	if !assert.Equal(t, DriftStopped, app.Drift) || !assert.Empty(t, app.Error) || !assert.Equal(t, pm.ServiceRunning, app.Status.State) {
+		t.Fail()
+	}
+
+	//a service that is not desired anymore is stopped
+	db.m.Lock()
+	delete(db.hashes["core:test:desired"], "app")
+	db.m.Unlock()
+
+	if drift := r.drift(map[string]settings.Startup{}); !assert.Equal(t, DriftUnwanted, drift["app"]) {
+		t.Fail()
+	}
+
+	r.reconcile()
+
+	if _, ok := db.hash("core:test:services")["app"]; !assert.False(t, ok) {
+		t.Fail()
+	}
+
+	if _, ok := r.mgr.Runner("app"); !assert.False(t, ok) {
+		t.Fail()
+	}
+}
@@ -65,18 +65,45 @@ type SinkConfig struct {
 	Password string
 }
 
+//QueueConfig command queue config
+type QueueConfig struct {
+	//Number of commands that can run concurrently on the queue
+	Slots int
+}
+
 //Settings main agent settings
 type AppSettings struct {
 	Main      struct {
 		MaxJobs int
 		Include string
 		Network string
+		//Journal dir to persist running and queued jobs across agent restarts
+		Journal string
+		//Run builtin core.* commands immediately even if MaxJobs is reached
+		BuiltinBypass bool
+		//Seconds to remember completed job IDs, so a retried command gets the same result instead of running again
+		IdempotencyWindow int
+		//(optional) Dir to record the pty sessions in
+		Sessions string
+		//(optional) File to persist the enabled/disabled state of the startup services in
+		ServicesState string
+		//Reload the include dir each time a file in it changes
+		WatchInclude bool
 	}
 
 	Sink      map[string]SinkConfig
 
 	Extension map[string]Extension
 
+	Queue     map[string]QueueConfig
+
+	History   struct {
+		//Number of completed jobs to keep in history
+		Size int
+		//(optional) File to persist the history in
+		File string
+	}
+
 	Logging   map[string]Logger
 
 	Stats     struct {
@@ -91,6 +118,13 @@ type AppSettings struct {
 	Channel   struct {
 		Cmds []string
 	}
+
+	Reconciler struct {
+		//Name of the sink to read the desired services from (and report their status to), empty disables it
+		Sink string
+		//Seconds between two reconciliations
+		Interval int
+	}
 }
 
 var Settings AppSettings
@@ -107,6 +141,12 @@ func (s *AppSettings) Validate() []error {
 		}
 	}
 
+	if name := s.Reconciler.Sink; name != "" {
+		if _, ok := s.Sink[name]; !ok {
+			errors = append(errors, fmt.Errorf("[reconciler] `sink`: unknown sink '%s'", name))
+		}
+	}
+
 	return errors
 }
 
@@ -2,16 +2,41 @@ package settings
 
 import (
 	"fmt"
+	"github.com/g8os/core.base/pm/core"
 	"github.com/g8os/core.base/utils"
 )
 
+/*
+Readiness tells when a startup service is ready, so the services that run after it can start. The service
+is ready once all the set conditions are met. A service that is not ready within Timeout seconds is
+failed (and stopped), so its dependents don't wait forever.
+*/
+type Readiness struct {
+	//Match is a regular expression that an output line of the service must match
+	Match string
+	//TCP is an address (host:port) that must accept connections
+	TCP string
+	//Socket is a unix socket that must accept connections
+	Socket string
+	//File is a file that must exist
+	File string
+	//Exec is a probe command (and its arguments) that must exit with 0
+	Exec []string
+	//Timeout in seconds, 0 waits forever
+	Timeout int
+}
+
 //StartupCmd startup command config
 type Startup struct {
-	After        []string
-	RunningDelay int
-	RunningMatch string
-	Name         string
-	Args         map[string]interface{}
+	After        []string               `json:"after"`
+	RunningDelay int                    `json:"running_delay"`
+	RunningMatch string                 `json:"running_match"`
+	Name         string                 `json:"name"`
+	Args         map[string]interface{} `json:"args"`
+	//(optional) liveness check, the service is restarted if it fails
+	Health       *core.HealthCheck      `json:"health"`
+	//(optional) readiness conditions, it takes precedence over RunningMatch and RunningDelay
+	Ready        *Readiness             `json:"ready"`
 
 	key          string
 }
@@ -24,6 +49,12 @@ func (s Startup) Key() string {
 	return s.key
 }
 
+//WithKey gets a copy of the service with the given key, for services that are not loaded from the include dir
+func (s Startup) WithKey(key string) Startup {
+	s.key = key
+	return s
+}
+
 func (s Startup) Weight(i *IncludedSettings, chain ...string) (int64, error) {
 	if utils.InString(chain, s.Key()) {
 		return 0, CyclicDependency
@@ -56,7 +56,9 @@ func (poll *sinkImpl) run() {
 
 	poll.mgr.AddRouteResultHandler(core.Route(poll.key), poll.handler)
 
-	for {
+	//stop polling once the manager is shutting down, so the commands still on the controller are left for the
+	//next agent run. A command that was taken while the manager was shutting down is answered CANCELLED.
+	for !poll.mgr.ShuttingDown() {
 		var command core.Command
 		err := poll.client.GetNext(&command)
 		if err != nil {
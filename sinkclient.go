@@ -15,6 +15,27 @@ const (
 	ReturnExpire = 300
 )
 
+//newSinkPool gets a redis pool to the sink url
+func newSinkPool(cfg *settings.SinkConfig) (*redis.Pool, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("expected url of format redis://<host>:<port> or redis:///unix.socket")
+	}
+
+	network := "tcp"
+	address := u.Host
+	if address == "" {
+		network = "unix"
+		address = u.Path
+	}
+
+	return utils.NewRedisPool(network, address, cfg.Password), nil
+}
+
 /*
 ControllerClient represents an active agent controller connection.
 */
@@ -31,24 +52,11 @@ NewSinkClient gets a new sink connection with the given identity. Identity is us
 introduce itself to the sink terminal.
 */
 func NewSinkClient(cfg *settings.SinkConfig, id string, responseQueue ...string) (SinkClient, error) {
-	u, err := url.Parse(cfg.URL)
+	pool, err := newSinkPool(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	if u.Scheme != "redis" {
-		return nil, fmt.Errorf("expected url of format redis://<host>:<port> or redis:///unix.socket")
-	}
-
-	network := "tcp"
-	address := u.Host
-	if address == "" {
-		network = "unix"
-		address = u.Path
-	}
-
-	pool := utils.NewRedisPool(network, address, cfg.Password)
-
 	client := &sinkClient{
 		id:    id,
 		url:   strings.TrimRight(cfg.URL, "/"),